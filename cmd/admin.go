@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// adminOnly restricts h to callers with the admin role. With authentication
+// disabled there is no caller to check, so admin endpoints are only served
+// when config.AdminEndpoints is set, as on a development machine, and
+// otherwise look like they don't exist.
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(config.APIKeys) == 0 {
+			if !config.AdminEndpoints {
+				http.NotFound(w, r)
+				return
+			}
+		} else if p, _ := principalFrom(r.Context()); p.Role != RoleAdmin {
+			handleClientError(w, http.StatusForbidden, "admin role required")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// redacted stands in for secret values in GET /admin/config.
+const redacted = "[REDACTED]"
+
+var (
+	uriCredentials   = regexp.MustCompile(`^(mongodb(?:\+srv)?://)[^@/]*@`)
+	uriSecretOptions = regexp.MustCompile(`(?i)([?&](?:[a-z]*password|authMechanismProperties)=)[^&]*`)
+)
+
+// redactURI hides the user info of a MongoDB URI and every option that
+// holds a secret: the password options, such as tlsCertificateKeyFilePassword
+// and proxyPassword, and the auth mechanism properties, which can hold
+// cloud credentials.
+func redactURI(uri string) string {
+	uri = uriCredentials.ReplaceAllString(uri, "${1}"+redacted+"@")
+	return uriSecretOptions.ReplaceAllString(uri, "${1}"+redacted)
+}
+
+// GetAdminConfig reports the configuration this instance loaded, as JSON
+// keyed like Config. Durations are written as strings such as "1m30s".
+// Secrets are redacted: the fields tagged admin:"redact" (see
+// redactConfigField) and the credentials in the MongoDB URI.
+func GetAdminConfig(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling GET request for /admin/config")
+	out := map[string]interface{}{}
+	v := reflect.ValueOf(config)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if !t.Field(i).IsExported() || name == "" || name == "-" {
+			continue
+		}
+		value := v.Field(i).Interface()
+		if t.Field(i).Tag.Get("admin") == "redact" {
+			out[name] = redactConfigField(value)
+		} else if d, ok := value.(time.Duration); ok {
+			out[name] = d.String()
+		} else {
+			out[name] = value
+		}
+	}
+
+	out["uri"] = redactURI(os.Getenv("URI"))
+	out["database"] = Database
+	out["collection"] = Collection
+	writeJSON(w, r, http.StatusOK, out)
+}
+
+// redactConfigField returns what GET /admin/config shows of a secret
+// Config field. API keys are replaced by the callers they authenticate;
+// anything else by a placeholder.
+func redactConfigField(value interface{}) interface{} {
+	apiKeys, ok := value.(map[string]Principal)
+	if !ok {
+		return redacted
+	}
+	keys := make([]map[string]string, 0, len(apiKeys))
+	for _, p := range apiKeys {
+		keys = append(keys, map[string]string{"key": redacted, "id": p.ID, "role": p.Role})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i]["id"] != keys[j]["id"] {
+			return keys[i]["id"] < keys[j]["id"]
+		}
+		return keys[i]["role"] < keys[j]["role"]
+	})
+	return keys
+}
+
+// collectionStats is the part of the collStats output useful for capacity
+// planning. Sizes are in bytes.
+type collectionStats struct {
+	Count          int64            `json:"count" bson:"count"`
+	Size           int64            `json:"size" bson:"size"`
+	StorageSize    int64            `json:"storage_size" bson:"storageSize"`
+	AvgObjSize     float64          `json:"avg_obj_size" bson:"avgObjSize"`
+	TotalIndexSize int64            `json:"total_index_size" bson:"totalIndexSize"`
+	IndexSizes     map[string]int64 `json:"index_sizes" bson:"indexSizes"`
+}
+
+// GetAdminStats reports the storage statistics of the people collection.
+// collStats isn't part of the Stable API, so this fails with
+// SERVER_API_STRICT set.
+func GetAdminStats(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling GET request for /admin/stats")
+	var stats collectionStats
+	cmd := bson.D{{Key: "collStats", Value: Collection}}
+	if err := client.Database(Database).RunCommand(r.Context(), cmd).Decode(&stats); err != nil {
+		handleError(w, err)
+		return
+	}
+	if stats.IndexSizes == nil {
+		stats.IndexSizes = map[string]int64{}
+	}
+	writeJSON(w, r, http.StatusOK, stats)
+}
+
+// recomputeBatch is the number of updates sent per BulkWrite.
+const recomputeBatch = 500
+
+// recomputeProgress is one NDJSON line of POST /admin/recompute.
+type recomputeProgress struct {
+	Field     string `json:"field"`
+	Processed int64  `json:"processed"`
+	Updated   int64  `json:"updated"`
+	Done      bool   `json:"done,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// RecomputeField recomputes a computed field for every person and writes
+// back the values that changed, in batches. Progress is streamed as NDJSON
+// after each batch; the last line has done set, or error if the run
+// stopped early. Documents are updated only where the stored value differs,
+// so an interrupted run can simply be repeated.
+func RecomputeField(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling POST request for /admin/recompute")
+	name := r.URL.Query().Get("field")
+	field, ok := computedFields[name]
+	if !ok {
+		handleClientError(w, http.StatusBadRequest, fmt.Sprintf("%q is not a computed field", name))
+		return
+	}
+
+	// Stop at shutdown rather than hold it up for the whole collection.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer context.AfterFunc(shutdownCtx, cancel)()
+	defer trackWorker("recompute")()
+
+	projection := bson.M{}
+	for _, in := range field.inputs {
+		projection[in] = 1
+	}
+	collection := client.Database(Database).Collection(Collection)
+	opts := streamFindOptions(ctx).SetProjection(projection).SetSort(bson.D{{Key: "_id", Value: 1}})
+	cur, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	defer cur.Close(context.Background())
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	progress := recomputeProgress{Field: name}
+	report := func() {
+		enc.Encode(progress)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	var batch []mongo.WriteModel
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		result, err := collection.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return err
+		}
+		progress.Processed += int64(len(batch))
+		progress.Updated += result.ModifiedCount
+		batch = batch[:0]
+		report()
+		return nil
+	}
+
+	for err == nil && cur.Next(ctx) {
+		var p Person
+		if err = cur.Decode(&p); err != nil {
+			break
+		}
+		v := field.compute(p)
+		batch = append(batch, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": p.ID, name: bson.M{"$ne": v}}).
+			SetUpdate(bson.M{"$set": bson.M{name: v}}))
+		if len(batch) == recomputeBatch {
+			err = flush()
+		}
+	}
+	if err == nil {
+		err = cur.Err()
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		log.Println("Error recomputing", name+":", err)
+		progress.Error = err.Error()
+	} else {
+		progress.Done = true
+	}
+	report()
+}
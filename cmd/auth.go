@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Principal is the caller a request was authenticated as.
+type Principal struct {
+	ID   string `json:"id"`
+	Role string `json:"role"`
+}
+
+// RoleAdmin always sees every field.
+const RoleAdmin = "admin"
+
+type principalKey struct{}
+
+// principalFrom returns the caller stored by authMiddleware. ok is false
+// when authentication is disabled or the request is anonymous.
+func principalFrom(ctx context.Context) (p Principal, ok bool) {
+	p, ok = ctx.Value(principalKey{}).(Principal)
+	return p, ok && p.ID != ""
+}
+
+// actor returns the id recorded as the author of a write: the caller's id,
+// or config.AnonymousActor for anonymous requests and when authentication
+// is disabled.
+func actor(ctx context.Context) string {
+	if p, ok := principalFrom(ctx); ok {
+		return p.ID
+	}
+	return config.AnonymousActor
+}
+
+// authMiddleware authenticates requests by API key, sent either as
+// "Authorization: Bearer <key>" or in X-API-Key. It does nothing when no
+// keys are configured. Requests without a key are rejected unless
+// config.AnonymousRole is set. Health probes are never authenticated.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(config.APIKeys) == 0 || strings.HasPrefix(r.URL.Path, "/health/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := r.Header.Get("X-API-Key")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimPrefix(auth, "Bearer ")
+		}
+		p, ok := lookupAPIKey(key)
+		if !ok {
+			if key != "" || config.AnonymousRole == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				handleClientError(w, http.StatusUnauthorized, "missing or invalid API key")
+				return
+			}
+			p = Principal{Role: config.AnonymousRole}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// lookupAPIKey compares key against every configured key in constant time.
+func lookupAPIKey(key string) (Principal, bool) {
+	var found Principal
+	ok := false
+	for k, p := range config.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			found, ok = p, true
+		}
+	}
+	return found, ok && key != ""
+}
+
+// parseAPIKeys parses "key:user:role" entries.
+func parseAPIKeys(entries []string) (map[string]Principal, error) {
+	keys := map[string]Principal{}
+	for _, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("API_KEYS: entries must look like key:user:role")
+		}
+		keys[parts[0]] = Principal{ID: parts[1], Role: parts[2]}
+	}
+	return keys, nil
+}
+
+// parseRoleFields parses "role=field,field;role=field" into the fields each
+// role may see.
+func parseRoleFields(raw string) (map[string][]string, error) {
+	roles := map[string][]string{}
+	for _, entry := range strings.Split(raw, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		role, fields, ok := strings.Cut(entry, "=")
+		role = strings.TrimSpace(role)
+		if !ok || role == "" {
+			return nil, fmt.Errorf("ROLE_FIELDS: entries must look like role=field,field")
+		}
+		for _, f := range splitParams([]string{fields}) {
+			if !isPersonField(f) {
+				return nil, fmt.Errorf("ROLE_FIELDS: unknown field %q for role %q", f, role)
+			}
+			roles[role] = append(roles[role], f)
+		}
+	}
+	return roles, nil
+}
+
+// visibleFields returns the Person fields the caller may see, or nil if it
+// may see all of them. The id is always visible.
+func visibleFields(ctx context.Context) []string {
+	p, _ := ctx.Value(principalKey{}).(Principal)
+	if p.Role == RoleAdmin {
+		return nil
+	}
+	return config.RoleFields[p.Role]
+}
+
+// fieldVisible reports whether the caller may see the Person field named
+// field.
+func fieldVisible(ctx context.Context, field string) bool {
+	fields := visibleFields(ctx)
+	if fields == nil || field == "id" {
+		return true
+	}
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// projectionFor returns the find projection limiting documents to the
+// caller's visible fields, or nil for no projection.
+func projectionFor(ctx context.Context) bson.M {
+	fields := visibleFields(ctx)
+	if fields == nil {
+		return nil
+	}
+	projection := bson.M{"_id": 1}
+	for _, f := range fields {
+		projection[f] = 1
+	}
+	return projection
+}
+
+// restrictFields strips fields the caller may not see from the people in
+// v, which is a Person or []Person. Reads are already projected in the
+// query; this covers responses built from request bodies and keeps zero
+// values of projected-out fields from showing up. ext selects bson field
+// names over JSON ones.
+func restrictFields(ctx context.Context, v interface{}, ext bool) (interface{}, error) {
+	fields := visibleFields(ctx)
+	if fields == nil {
+		return v, nil
+	}
+	allowed := map[string]bool{"id": true, "_id": true}
+	for _, f := range fields {
+		allowed[f] = true
+	}
+
+	switch t := v.(type) {
+	case Person:
+		return restrictPerson(t, allowed, ext)
+	case []Person:
+		out := make([]interface{}, len(t))
+		for i, p := range t {
+			var err error
+			if out[i], err = restrictPerson(p, allowed, ext); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func restrictPerson(p Person, allowed map[string]bool, ext bool) (interface{}, error) {
+	if ext {
+		raw, err := bson.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		var doc bson.D
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			return nil, err
+		}
+		kept := bson.D{}
+		for _, e := range doc {
+			if allowed[e.Key] {
+				kept = append(kept, e)
+			}
+		}
+		return kept, nil
+	}
+
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	for k := range doc {
+		if !allowed[k] {
+			delete(doc, k)
+		}
+	}
+	return doc, nil
+}
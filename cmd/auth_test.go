@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	got, err := parseAPIKeys([]string{"k1:alice:admin", "k2:bob:reader"})
+	want := map[string]Principal{"k1": {ID: "alice", Role: "admin"}, "k2": {ID: "bob", Role: "reader"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAPIKeys = %v, %v; want %v", got, err, want)
+	}
+	for _, entry := range []string{"k1:alice", "k1::admin", ":alice:admin", "k1:alice:admin:x"} {
+		if _, err := parseAPIKeys([]string{entry}); err == nil {
+			t.Errorf("parseAPIKeys accepted %q", entry)
+		}
+	}
+}
+
+func TestParseRoleFields(t *testing.T) {
+	got, err := parseRoleFields(" reader = name, age ; support=email;")
+	want := map[string][]string{"reader": {"name", "age"}, "support": {"email"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRoleFields = %v, %v; want %v", got, err, want)
+	}
+	for raw, msg := range map[string]string{
+		"reader":             "ROLE_FIELDS: entries must look like role=field,field",
+		"=name":              "ROLE_FIELDS: entries must look like role=field,field",
+		"reader=name,salary": `ROLE_FIELDS: unknown field "salary" for role "reader"`,
+	} {
+		if _, err := parseRoleFields(raw); err == nil || err.Error() != msg {
+			t.Errorf("parseRoleFields(%q) error = %v, want %q", raw, err, msg)
+		}
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.APIKeys = map[string]Principal{"secret": {ID: "alice", Role: "reader"}}
+
+	var seen Principal
+	handler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = r.Context().Value(principalKey{}).(Principal)
+	}))
+	serve := func(path string, header http.Header) int {
+		seen = Principal{}
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header = header
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	for _, header := range []http.Header{
+		{"Authorization": {"Bearer secret"}},
+		{"X-Api-Key": {"secret"}},
+	} {
+		if code := serve("/people", header); code != http.StatusOK || seen.ID != "alice" {
+			t.Errorf("with %v: status %d, principal %+v", header, code, seen)
+		}
+	}
+	for _, header := range []http.Header{{}, {"X-Api-Key": {"wrong"}}, {"Authorization": {"Basic secret"}}} {
+		if code := serve("/people", header); code != http.StatusUnauthorized {
+			t.Errorf("with %v: status %d, want 401", header, code)
+		}
+	}
+	if code := serve("/health/live", http.Header{}); code != http.StatusOK {
+		t.Errorf("health probe: status %d, want 200", code)
+	}
+
+	config.AnonymousRole = "guest"
+	if code := serve("/people", http.Header{}); code != http.StatusOK || seen.Role != "guest" || seen.ID != "" {
+		t.Errorf("anonymous: status %d, principal %+v", code, seen)
+	}
+	// A wrong key is still refused rather than treated as anonymous.
+	if code := serve("/people", http.Header{"X-Api-Key": {"wrong"}}); code != http.StatusUnauthorized {
+		t.Errorf("wrong key with an anonymous role: status %d, want 401", code)
+	}
+}
+
+func TestRestrictFields(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.RoleFields = map[string][]string{"reader": {"name"}}
+	reader := context.WithValue(context.Background(), principalKey{}, Principal{ID: "bob", Role: "reader"})
+	admin := context.WithValue(context.Background(), principalKey{}, Principal{ID: "alice", Role: RoleAdmin})
+	p := Person{ID: primitive.NewObjectID(), Name: "Ann", Age: 30, Email: "ann@example.com"}
+
+	got, err := restrictFields(reader, p, false)
+	if want := map[string]interface{}{"id": p.ID.Hex(), "name": "Ann"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("restrictFields for a reader = %v, %v; want %v", got, err, want)
+	}
+	if got, _ := restrictFields(admin, p, false); !reflect.DeepEqual(got, p) {
+		t.Errorf("restrictFields for an admin = %v, want the person unchanged", got)
+	}
+	if got := projectionFor(reader); !reflect.DeepEqual(got, bson.M{"_id": 1, "name": 1}) {
+		t.Errorf("projectionFor a reader = %v", got)
+	}
+	if got := projectionFor(admin); got != nil {
+		t.Errorf("projectionFor an admin = %v, want nil", got)
+	}
+}
+
+func TestFieldVisible(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.RoleFields = map[string][]string{"reader": {"name", "tags"}}
+	reader := context.WithValue(context.Background(), principalKey{}, Principal{ID: "bob", Role: "reader"})
+
+	for field, want := range map[string]bool{"name": true, "tags": true, "id": true, "email": false, "age": false} {
+		if got := fieldVisible(reader, field); got != want {
+			t.Errorf("fieldVisible(reader, %q) = %v, want %v", field, got, want)
+		}
+	}
+	if !fieldVisible(context.Background(), "email") {
+		t.Error("email hidden with authentication disabled")
+	}
+}
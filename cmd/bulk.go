@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// bulkPreviewSize is the number of matching people shown by a preview.
+const bulkPreviewSize = 5
+
+// bulkUpdateRequest is the body of POST /people/bulk-update and its
+// preview.
+type bulkUpdateRequest struct {
+	Filter PeopleQuery `json:"filter"`
+	Patch  personPatch `json:"patch"`
+
+	// Confirm must be set to update every person, which is what an empty
+	// filter selects.
+	Confirm bool `json:"confirm"`
+}
+
+// bulkUpdate is a validated bulk update.
+type bulkUpdate struct {
+	filter bson.M
+	// fields are the patched fields in stored form.
+	fields bson.M
+	unset  bson.M
+}
+
+// update returns the update document, stamped for the caller.
+func (b bulkUpdate) update(r *http.Request) bson.M {
+	set := bson.M{"updated_at": now(), "updated_by": actor(r.Context())}
+	for k, v := range b.fields {
+		set[k] = v
+	}
+	update := bson.M{"$set": set}
+	if len(b.unset) > 0 {
+		update["$unset"] = b.unset
+	}
+	return update
+}
+
+// parseBulkUpdate reads and validates a bulk update request. The patch is
+// sanitized, normalized and validated like a PATCH, field by field, since
+// there is no single document to merge it into. On failure the response
+// has already been written.
+func parseBulkUpdate(w http.ResponseWriter, r *http.Request) (bulkUpdate, bool) {
+	var req bulkUpdateRequest
+	if err := decodeObject(r.Body, &req, true); err != nil {
+		if errors.Is(err, errNotObject) {
+			handleClientError(w, http.StatusBadRequest, err.Error())
+		} else {
+			handleClientError(w, http.StatusBadRequest, "malformed JSON: "+err.Error())
+		}
+		return bulkUpdate{}, false
+	}
+	filter, err := req.Filter.filter(r.Context())
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return bulkUpdate{}, false
+	}
+	if len(filter) == 0 && !req.Confirm {
+		handleClientError(w, http.StatusBadRequest, "empty filter matches every person; set confirm to true to proceed")
+		return bulkUpdate{}, false
+	}
+
+	p := req.Patch
+	if p.Name == nil && p.Age == nil && p.Address == nil && p.Email == nil && p.Tags == nil {
+		handleClientError(w, http.StatusUnprocessableEntity, "patch must change at least one field")
+		return bulkUpdate{}, false
+	}
+	if p.Email != nil {
+		handleClientError(w, http.StatusUnprocessableEntity, "email is unique per person and can't be bulk-updated")
+		return bulkUpdate{}, false
+	}
+
+	// Validate the patched values on a person that is otherwise valid.
+	person := Person{Name: "-"}
+	p.apply(&person)
+	if err := sanitizePerson(&person); err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return bulkUpdate{}, false
+	}
+	normalizePerson(&person)
+	if err := checkTagLimits(person.Tags); err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return bulkUpdate{}, false
+	}
+	if err := person.Validate(); err != nil {
+		handleClientError(w, http.StatusUnprocessableEntity, err.Error())
+		return bulkUpdate{}, false
+	}
+
+	b := bulkUpdate{filter: liveFilter(filter), fields: bson.M{}, unset: bson.M{}}
+	if p.Name != nil {
+		b.fields["name"] = person.Name
+	}
+	if p.Age != nil {
+		b.fields["age"] = person.Age
+	}
+	if p.Address != nil {
+		if person.Address == "" && config.AddressMode == addressOmit {
+			b.unset["address"] = ""
+		} else {
+			b.fields["address"] = person.Address
+		}
+	}
+	if p.Tags != nil {
+		b.fields["tags"] = person.Tags
+	}
+	// Computed fields can only be set when the patch holds all of their
+	// inputs.
+	for name, f := range computedFields {
+		complete := true
+		for _, in := range f.inputs {
+			if _, ok := b.fields[in]; !ok {
+				complete = false
+			}
+		}
+		if complete {
+			b.fields[name] = f.compute(person)
+		}
+	}
+	return b, true
+}
+
+// BulkUpdatePeople applies a patch to every person matching the filter.
+func BulkUpdatePeople(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling POST request for /people/bulk-update")
+	b, ok := parseBulkUpdate(w, r)
+	if !ok {
+		return
+	}
+	collection := client.Database(Database).Collection(Collection)
+	result, err := collection.UpdateMany(r.Context(), b.filter, b.update(r))
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]int64{
+		"matched":  result.MatchedCount,
+		"modified": result.ModifiedCount,
+	})
+}
+
+// PreviewBulkUpdate validates a bulk update like BulkUpdatePeople and
+// reports how many people it would match, a few of them, and the fields it
+// would set, without writing anything.
+func PreviewBulkUpdate(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling POST request for /people/bulk-update/preview")
+	b, ok := parseBulkUpdate(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	collection := client.Database(Database).Collection(Collection)
+	n, err := collection.CountDocuments(ctx, b.filter, countOptions(ctx))
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	opts := findOptions(ctx).SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(bulkPreviewSize)
+	if projection := projectionFor(ctx); projection != nil {
+		opts.SetProjection(projection)
+	}
+	cur, err := collection.Find(ctx, b.filter, opts)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	sample := []Person{}
+	if err := cur.All(ctx, &sample); err != nil {
+		handleError(w, err)
+		return
+	}
+	// writeJSON only renders the types it knows, so the sample is
+	// prepared here.
+	ext := r.URL.Query().Get("ext_json") == "true"
+	restricted, err := restrictFields(ctx, inZone(ctx, sample), ext)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	unset := make([]string, 0, len(b.unset))
+	for k := range b.unset {
+		unset = append(unset, k)
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"matched": n,
+		"sample":  restricted,
+		"set":     b.fields,
+		"unset":   unset,
+	})
+}
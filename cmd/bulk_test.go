@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseBulkUpdate(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.AddressMode = addressOmit
+
+	tests := []struct {
+		body   string
+		status int // 0 for success
+	}{
+		{`{"filter":{"name":"Ann"},"patch":{"age":31}}`, 0},
+		{`{"patch":{"age":31},"confirm":true}`, 0},
+		{`{"patch":{"age":31}}`, http.StatusBadRequest},
+		{`null`, http.StatusBadRequest},
+		{`{"filter":{"name":"Ann"},"patch":{"age":31},"extra":1}`, http.StatusBadRequest},
+		{`{"filter":{"name":"Ann"},"patch":{}}`, http.StatusUnprocessableEntity},
+		{`{"filter":{"name":"Ann"},"patch":{"email":"a@example.com"}}`, http.StatusUnprocessableEntity},
+		{`{"filter":{"name":"Ann"},"patch":{"age":200}}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/people/bulk-update", strings.NewReader(tt.body))
+		_, ok := parseBulkUpdate(rec, r)
+		switch {
+		case tt.status == 0 && !ok:
+			t.Errorf("parseBulkUpdate(%s) failed with %d: %s", tt.body, rec.Code, rec.Body)
+		case tt.status != 0 && (ok || rec.Code != tt.status):
+			t.Errorf("parseBulkUpdate(%s) = %v, %d; want false, %d", tt.body, ok, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestBulkUpdateDocument(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.AddressMode = addressOmit
+
+	body := `{"filter":{"name":"Ann"},"patch":{"age":31,"address":""}}`
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/people/bulk-update", strings.NewReader(body))
+	b, ok := parseBulkUpdate(rec, r)
+	if !ok {
+		t.Fatalf("parseBulkUpdate failed with %d: %s", rec.Code, rec.Body)
+	}
+
+	update := b.update(r)
+	set, _ := update["$set"].(bson.M)
+	if set["age"] != 31 || set["updated_by"] != config.AnonymousActor || set["updated_at"] == nil {
+		t.Errorf("$set = %v, want age 31 stamped for the caller", set)
+	}
+	if _, ok := set["address"]; ok {
+		t.Errorf("$set has an address %v in omit mode", set["address"])
+	}
+	if unset, _ := update["$unset"].(bson.M); unset == nil || unset["address"] == nil {
+		t.Errorf("update = %v, want the address unset", update)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// computedField is a field derived from other Person fields. It is stored
+// with every write so queries can use it, but isn't part of the API.
+type computedField struct {
+	// inputs are the document fields the value is computed from.
+	inputs  []string
+	compute func(p Person) interface{}
+}
+
+// computedFields lists the derived fields by stored name. Documents written
+// before a field was added lack it until POST /admin/recompute is run.
+var computedFields = map[string]computedField{
+	"slug": {
+		inputs:  []string{"name"},
+		compute: func(p Person) interface{} { return slugify(p.Name) },
+	},
+}
+
+// slugify lowercases s and joins its runs of letters and digits with "-".
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestSlugify(t *testing.T) {
+	for s, want := range map[string]string{
+		"Ann Lee":             "ann-lee",
+		"  Jean-Luc  O'Neil ": "jean-luc-o-neil",
+		"Zoë 2nd":             "zoë-2nd",
+		"!!!":                 "",
+		"":                    "",
+	} {
+		if got := slugify(s); got != want {
+			t.Errorf("slugify(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestStoredPersonComputesFields(t *testing.T) {
+	doc, err := storedPerson(Person{Name: "Ann Lee"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc["slug"] != "ann-lee" {
+		t.Errorf("stored slug = %v, want ann-lee", doc["slug"])
+	}
+}
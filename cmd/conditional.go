@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// setLastModified sets the Last-Modified header from the person's
+// updated_at timestamp, when it has one.
+func setLastModified(w http.ResponseWriter, person Person) {
+	if person.UpdatedAt != nil {
+		w.Header().Set("Last-Modified", person.UpdatedAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// unmodifiedSinceFilter returns filter narrowed by the request's
+// If-Unmodified-Since precondition, so that the write only matches a
+// document whose updated_at is no later than the given date. HTTP dates
+// have second precision, so the whole second is allowed. A document
+// without updated_at can't satisfy the precondition. Invalid dates are
+// ignored, as RFC 9110 requires.
+func unmodifiedSinceFilter(r *http.Request, filter bson.M) (bson.M, bool) {
+	since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since"))
+	if err != nil {
+		return filter, false
+	}
+	narrowed := bson.M{}
+	for k, v := range filter {
+		narrowed[k] = v
+	}
+	narrowed["updated_at"] = bson.M{"$lt": since.Add(time.Second)}
+	return narrowed, true
+}
+
+// handleNoMatch responds to a conditional write that matched nothing: 412
+// if the person exists, so the precondition is what failed, or 404.
+func handleNoMatch(ctx context.Context, w http.ResponseWriter, filter bson.M, conditional bool) {
+	if conditional {
+		collection := client.Database(Database).Collection(Collection)
+		n, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+		if n > 0 {
+			handleClientError(w, http.StatusPreconditionFailed, "person was modified since the given date")
+			return
+		}
+	}
+	handleClientError(w, http.StatusNotFound, "person not found")
+}
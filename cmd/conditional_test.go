@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestUnmodifiedSinceFilter(t *testing.T) {
+	filter := bson.M{"name": "Ann"}
+
+	r := httptest.NewRequest("PUT", "/people/Ann", nil)
+	if got, conditional := unmodifiedSinceFilter(r, filter); conditional || len(got) != 1 {
+		t.Errorf("without If-Unmodified-Since: %v, %v; want the filter unchanged", got, conditional)
+	}
+	// Invalid dates are ignored.
+	r.Header.Set("If-Unmodified-Since", "yesterday")
+	if _, conditional := unmodifiedSinceFilter(r, filter); conditional {
+		t.Error("an invalid If-Unmodified-Since made the write conditional")
+	}
+
+	r.Header.Set("If-Unmodified-Since", "Fri, 01 Mar 2024 12:00:00 GMT")
+	got, conditional := unmodifiedSinceFilter(r, filter)
+	want := bson.M{"$lt": time.Date(2024, 3, 1, 12, 0, 1, 0, time.UTC)}
+	if !conditional || got["name"] != "Ann" || !reflect.DeepEqual(got["updated_at"], want) {
+		t.Errorf("unmodifiedSinceFilter = %v, %v; want name and updated_at before %v", got, conditional, want["$lt"])
+	}
+	if _, ok := filter["updated_at"]; ok {
+		t.Error("unmodifiedSinceFilter changed the filter it was given")
+	}
+}
+
+func TestHandleNoMatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		conditional bool
+		count       int64
+		want        int
+	}{
+		{"unconditional", false, 0, http.StatusNotFound},
+		{"modified since", true, 1, http.StatusPreconditionFailed},
+		{"missing", true, 0, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		runMock(t, tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(countResponse(tt.count))
+			rec := httptest.NewRecorder()
+			handleNoMatch(httptest.NewRequest("PUT", "/people/Ann", nil).Context(), rec, bson.M{"name": "Ann"}, tt.conditional)
+			if rec.Code != tt.want {
+				mt.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
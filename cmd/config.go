@@ -0,0 +1,567 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings read from the environment at startup.
+type Config struct {
+	// BodyLog enables logging of request and response bodies. It is meant
+	// for debugging only and is off by default.
+	BodyLog         bool     `json:"body_log"`
+	BodyLogMaxBytes int      `json:"body_log_max_bytes"`
+	BodyLogRedact   []string `json:"body_log_redact"`
+
+	// GzipLevel is the compression level used for gzip responses, from
+	// gzip.BestSpeed (1) to gzip.BestCompression (9).
+	GzipLevel int `json:"gzip_level"`
+
+	// ShutdownTimeout bounds how long shutdown waits for in-flight
+	// requests, and then again for background workers, before
+	// disconnecting from MongoDB. With StreamDrainTimeout in between, the
+	// longest a shutdown takes is twice ShutdownTimeout plus
+	// StreamDrainTimeout.
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
+
+	// StreamDrainTimeout is the extra time given on shutdown to responses
+	// that stream: change streams, CSV exports and recomputes. Change
+	// streams never end on their own, so they are closed when it runs out.
+	StreamDrainTimeout time.Duration `json:"stream_drain_timeout"`
+
+	// DefaultPageSize is used when a listing doesn't ask for a page size;
+	// MaxPageSize is the largest page a client may request.
+	DefaultPageSize int `json:"default_page_size"`
+	MaxPageSize     int `json:"max_page_size"`
+
+	// ZeroPageSize is how an explicit page_size=0 is treated: "default"
+	// (the default) uses DefaultPageSize, "reject" answers 400. It never
+	// means no limit.
+	ZeroPageSize string `json:"zero_page_size"`
+
+	// NaturalKey is the field that identifies a person in /people/{id}
+	// when the segment isn't an ObjectID. Empty disables the fallback.
+	NaturalKey string `json:"natural_key"`
+
+	// NormalizeEmail stores emails trimmed and lowercased.
+	NormalizeEmail bool `json:"normalize_email"`
+
+	// HealthCheckInterval is how often MongoDB is pinged in the background
+	// to keep readiness current; 0 disables the monitor.
+	HealthCheckInterval time.Duration `json:"health_check_interval"`
+	HealthCheckTimeout  time.Duration `json:"health_check_timeout"`
+
+	// HealthWriteCheck makes the health monitor also write to the _health
+	// collection, so a primary that is reachable but can't take writes
+	// marks the service unready. It always writes the same document.
+	HealthWriteCheck bool `json:"health_write_check"`
+
+	// RequestIDHeaders are checked in order for a client supplied request
+	// id; RequestIDResponseHeader carries the id used back to the client.
+	RequestIDHeaders        []string `json:"request_id_headers"`
+	RequestIDResponseHeader string   `json:"request_id_response_header"`
+
+	// ServerAPIStrict makes the server reject commands and operators that
+	// aren't part of Stable API version 1.
+	ServerAPIStrict bool `json:"server_api_strict"`
+
+	// StaticMaxAge is the Cache-Control max-age for metadata endpoints
+	// whose responses only change on redeploy.
+	StaticMaxAge time.Duration `json:"static_max_age"`
+
+	// APIKeys maps each API key to the caller it authenticates. With no
+	// keys configured, authentication is disabled. AnonymousRole, when set,
+	// is the role given to requests that send no key.
+	APIKeys       map[string]Principal `json:"api_keys" admin:"redact"`
+	AnonymousRole string               `json:"anonymous_role"`
+
+	// AnonymousActor is stored in created_by and updated_by for writes
+	// without an authenticated caller.
+	AnonymousActor string `json:"anonymous_actor"`
+
+	// RoleFields limits the Person fields each role can see. Roles not
+	// listed, and the admin role, see every field.
+	RoleFields map[string][]string `json:"role_fields"`
+
+	// AllowDiskUse lets sorts and aggregation stages that exceed the
+	// server's 100MB memory limit spill to temporary files instead of
+	// failing. Spilling is much slower than an in-memory sort and adds disk
+	// I/O on the server; an index on the sort key avoids both.
+	AllowDiskUse bool `json:"allow_disk_use"`
+
+	// FindBatchSize is the cursor batch size for queries whose results are
+	// streamed: the CSV export, NDJSON group-by and recompute. Larger
+	// batches mean fewer round trips but more memory held per request; the
+	// default of 1000 suits documents of a few KB. Zero leaves it to the
+	// server, which sends 101 documents first and then up to 16MB.
+	FindBatchSize int `json:"find_batch_size"`
+
+	// Limits on queries in the where DSL.
+	QueryMaxConditions int `json:"query_max_conditions"`
+	QueryMaxDepth      int `json:"query_max_depth"`
+	QueryMaxIn         int `json:"query_max_in"`
+
+	// IndexStrict fails startup when an index can't be created because
+	// the server's feature compatibility version is too old; otherwise the
+	// index is skipped with a warning.
+	IndexStrict bool `json:"index_strict"`
+
+	// AddressMode is how a person without an address is stored: "omit"
+	// (the default) leaves the field out, "empty" stores "".
+	AddressMode string `json:"address_mode"`
+
+	// Warmup runs a couple of cheap queries at startup, bounded by
+	// WarmupTimeout, before the service reports ready.
+	Warmup        bool          `json:"warmup"`
+	WarmupTimeout time.Duration `json:"warmup_timeout"`
+
+	// RetryAfter is the delay suggested to clients on retryable errors
+	// (429, 503 and 504) when the handler doesn't pick one.
+	RetryAfter time.Duration `json:"retry_after"`
+
+	// InputMode is how text fields with control characters or surrounding
+	// whitespace are handled: "sanitize" (the default) cleans them up,
+	// "reject" answers 400.
+	InputMode string `json:"input_mode"`
+
+	// ReadOnly rejects every write with 503 for the life of the process,
+	// for serving reads while the database must not change.
+	ReadOnly bool `json:"read_only"`
+
+	// AdminEndpoints serves the /admin endpoints while authentication is
+	// disabled. With API keys configured they need the admin role instead.
+	AdminEndpoints bool `json:"admin_endpoints"`
+
+	// QueryMaxTime is the longest a list or aggregate query may run on the
+	// server, and the ceiling for the max_time_ms parameter; 0 removes the
+	// limit.
+	QueryMaxTime time.Duration `json:"query_max_time"`
+
+	// StreamMaxTime replaces QueryMaxTime for streamed responses (CSV
+	// export, group-by and recompute), which read until the cursor is
+	// exhausted and would otherwise be cut off mid-response. The default of
+	// 0 sets no limit; max_time_ms still shortens it.
+	StreamMaxTime time.Duration `json:"stream_max_time"`
+
+	// SortTags stores tags in sorted order. Duplicates are always dropped.
+	SortTags bool `json:"sort_tags"`
+
+	// StrictParams rejects unknown query parameters on every request, as
+	// ?strict=true does for a single one.
+	StrictParams bool `json:"strict_params"`
+
+	// ETagWeak sends weak ETags (W/"...") on single-person responses,
+	// which only promise semantic equivalence; strong ETags, the default,
+	// change with every byte of the body and are required by If-Match.
+	ETagWeak bool `json:"etag_weak"`
+
+	// SoftDelete makes DELETE set deleted_at instead of removing the
+	// document; every read then leaves such people out. A soft-deleted
+	// person keeps its email, so the unique index still blocks reusing it.
+	// RedeletePolicy is the outcome of deleting one again: "not_found" (the
+	// default) answers 404, "noop" answers 204.
+	SoftDelete     bool   `json:"soft_delete"`
+	RedeletePolicy string `json:"redelete_policy"`
+
+	// IDMismatch is what PUT does with a body id that isn't the replaced
+	// person's: "reject" (the default) answers 409, "ignore" drops it. A
+	// body without an id is always fine.
+	IDMismatch string `json:"id_mismatch"`
+
+	// ServerSelectionTimeout is how long an operation, and the startup
+	// ping, waits for a suitable server before failing. ConnectTimeout
+	// bounds a single connection handshake within that wait, so it can't be
+	// longer. Both override the URI's serverSelectionTimeoutMS and
+	// connectTimeoutMS.
+	ServerSelectionTimeout time.Duration `json:"server_selection_timeout"`
+	ConnectTimeout         time.Duration `json:"connect_timeout"`
+
+	// RateLimit caps the requests per second this instance serves, with
+	// bursts of up to RateLimitBurst (by default the rate itself). Over it,
+	// requests get a 429. Zero disables the limit. RateLimitSlowStart, if
+	// set, starts the limit at a tenth of its value once the service is
+	// ready and raises it linearly to the full rate over that period, so a
+	// restarted fleet doesn't hit cold caches and pools at full speed.
+	RateLimit          int           `json:"rate_limit"`
+	RateLimitBurst     int           `json:"rate_limit_burst"`
+	RateLimitSlowStart time.Duration `json:"rate_limit_slow_start"`
+
+	// StartupGate answers data endpoints with 503 until the service is
+	// ready for the first time. Without it they are served as soon as the
+	// server listens, before the warm-up has finished.
+	StartupGate bool `json:"startup_gate"`
+
+	// PoolMaxSize is the most connections kept to each server, and
+	// PoolMaxConnecting how many may be established at once; they override
+	// the URI's maxPoolSize and maxConnecting. PoolExhausted
+	// picks what happens to requests when all connections are busy:
+	// "queue" (the default) waits for one, trading latency for
+	// availability, and "fail_fast" answers 503 with Retry-After if none is
+	// returned within PoolWaitTimeout, so clients can back off or go to
+	// another instance.
+	PoolMaxSize       int           `json:"pool_max_size"`
+	PoolMaxConnecting int           `json:"pool_max_connecting"`
+	PoolExhausted     string        `json:"pool_exhausted"`
+	PoolWaitTimeout   time.Duration `json:"pool_wait_timeout"`
+
+	// PartialResults answers a listing whose cursor fails after some
+	// people were read with those people, flagged as truncated, instead of
+	// an error.
+	PartialResults bool `json:"partial_results"`
+
+	// MaxTags is the most tags a person may have, and MaxTagLength the
+	// longest a tag may be, in characters. Requests over either get a 400.
+	// Zero disables a limit. People stored before a limit was lowered keep
+	// their tags, but can't be tagged further or saved until they comply.
+	MaxTags      int `json:"max_tags"`
+	MaxTagLength int `json:"max_tag_length"`
+
+	// Timezone is the IANA zone timestamps are rendered in when a request
+	// doesn't pass tz. Storage is always UTC.
+	Timezone string `json:"timezone"`
+	zone     *time.Location
+}
+
+var config Config
+
+func loadConfig() (Config, error) {
+	var c Config
+	var err error
+
+	if c.BodyLog, err = envBool("BODY_LOG", false); err != nil {
+		return c, err
+	}
+	if c.BodyLogMaxBytes, err = envInt("BODY_LOG_MAX_BYTES", 4096); err != nil {
+		return c, err
+	}
+	if c.BodyLogMaxBytes <= 0 {
+		return c, fmt.Errorf("BODY_LOG_MAX_BYTES must be positive, got %d", c.BodyLogMaxBytes)
+	}
+	c.BodyLogRedact = envList("BODY_LOG_REDACT", []string{"password", "token", "secret"})
+
+	if c.GzipLevel, err = envInt("GZIP_LEVEL", 5); err != nil {
+		return c, err
+	}
+	if c.GzipLevel < gzip.BestSpeed || c.GzipLevel > gzip.BestCompression {
+		return c, fmt.Errorf("GZIP_LEVEL must be between %d and %d, got %d", gzip.BestSpeed, gzip.BestCompression, c.GzipLevel)
+	}
+
+	if c.ShutdownTimeout, err = envDuration("SHUTDOWN_TIMEOUT", 10*time.Second); err != nil {
+		return c, err
+	}
+	if c.StreamDrainTimeout, err = envDuration("STREAM_DRAIN_TIMEOUT", time.Minute); err != nil {
+		return c, err
+	}
+
+	if c.DefaultPageSize, err = envInt("DEFAULT_PAGE_SIZE", 100); err != nil {
+		return c, err
+	}
+	if c.MaxPageSize, err = envInt("MAX_PAGE_SIZE", 1000); err != nil {
+		return c, err
+	}
+	if c.DefaultPageSize < 1 || c.DefaultPageSize > c.MaxPageSize {
+		return c, fmt.Errorf("DEFAULT_PAGE_SIZE must be between 1 and MAX_PAGE_SIZE (%d), got %d", c.MaxPageSize, c.DefaultPageSize)
+	}
+	c.ZeroPageSize = os.Getenv("ZERO_PAGE_SIZE")
+	if c.ZeroPageSize == "" {
+		c.ZeroPageSize = zeroPageSizeDefault
+	}
+	if c.ZeroPageSize != zeroPageSizeDefault && c.ZeroPageSize != zeroPageSizeReject {
+		return c, fmt.Errorf("ZERO_PAGE_SIZE must be %q or %q, got %q", zeroPageSizeDefault, zeroPageSizeReject, c.ZeroPageSize)
+	}
+
+	c.NaturalKey = os.Getenv("NATURAL_KEY")
+	if _, ok := os.LookupEnv("NATURAL_KEY"); !ok {
+		c.NaturalKey = "email"
+	}
+	if c.NaturalKey != "" && (!filterFields[c.NaturalKey] || c.NaturalKey == "age") {
+		return c, fmt.Errorf("NATURAL_KEY %q is not a string person field", c.NaturalKey)
+	}
+
+	if c.NormalizeEmail, err = envBool("NORMALIZE_EMAIL", true); err != nil {
+		return c, err
+	}
+
+	if c.HealthCheckInterval, err = envDuration("HEALTH_CHECK_INTERVAL", 10*time.Second); err != nil {
+		return c, err
+	}
+	if c.HealthCheckTimeout, err = envDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second); err != nil {
+		return c, err
+	}
+	if c.HealthCheckTimeout == 0 {
+		return c, fmt.Errorf("HEALTH_CHECK_TIMEOUT must be positive")
+	}
+	if c.HealthWriteCheck, err = envBool("HEALTH_WRITE_CHECK", false); err != nil {
+		return c, err
+	}
+
+	c.RequestIDHeaders = envList("REQUEST_ID_HEADERS", []string{"X-Request-ID"})
+	c.RequestIDResponseHeader = os.Getenv("REQUEST_ID_RESPONSE_HEADER")
+	if c.RequestIDResponseHeader == "" {
+		c.RequestIDResponseHeader = "X-Request-ID"
+	}
+
+	if c.ServerAPIStrict, err = envBool("SERVER_API_STRICT", false); err != nil {
+		return c, err
+	}
+
+	if c.StaticMaxAge, err = envDuration("STATIC_MAX_AGE", 5*time.Minute); err != nil {
+		return c, err
+	}
+
+	if c.APIKeys, err = parseAPIKeys(envList("API_KEYS", nil)); err != nil {
+		return c, err
+	}
+	c.AnonymousRole = os.Getenv("ANONYMOUS_ROLE")
+	c.AnonymousActor = os.Getenv("ANONYMOUS_ACTOR")
+	if c.AnonymousActor == "" {
+		c.AnonymousActor = "anonymous"
+	}
+	if c.RoleFields, err = parseRoleFields(os.Getenv("ROLE_FIELDS")); err != nil {
+		return c, err
+	}
+
+	if c.AllowDiskUse, err = envBool("ALLOW_DISK_USE", true); err != nil {
+		return c, err
+	}
+	if c.FindBatchSize, err = envInt("FIND_BATCH_SIZE", 1000); err != nil {
+		return c, err
+	}
+	if c.FindBatchSize < 0 || c.FindBatchSize > math.MaxInt32 {
+		return c, fmt.Errorf("FIND_BATCH_SIZE must be between 0 and %d", math.MaxInt32)
+	}
+
+	for _, limit := range []struct {
+		key string
+		dst *int
+		def int
+	}{
+		{"QUERY_MAX_CONDITIONS", &c.QueryMaxConditions, 20},
+		{"QUERY_MAX_DEPTH", &c.QueryMaxDepth, 4},
+		{"QUERY_MAX_IN", &c.QueryMaxIn, 100},
+	} {
+		if *limit.dst, err = envInt(limit.key, limit.def); err != nil {
+			return c, err
+		}
+		if *limit.dst < 1 {
+			return c, fmt.Errorf("%s must be positive, got %d", limit.key, *limit.dst)
+		}
+	}
+
+	if c.IndexStrict, err = envBool("INDEX_STRICT", false); err != nil {
+		return c, err
+	}
+
+	c.AddressMode = os.Getenv("ADDRESS_MODE")
+	if c.AddressMode == "" {
+		c.AddressMode = addressOmit
+	}
+	if c.AddressMode != addressOmit && c.AddressMode != addressEmpty {
+		return c, fmt.Errorf("ADDRESS_MODE must be %q or %q, got %q", addressOmit, addressEmpty, c.AddressMode)
+	}
+
+	if c.Warmup, err = envBool("WARMUP", true); err != nil {
+		return c, err
+	}
+	if c.WarmupTimeout, err = envDuration("WARMUP_TIMEOUT", 10*time.Second); err != nil {
+		return c, err
+	}
+
+	if c.RetryAfter, err = envDuration("RETRY_AFTER", 2*time.Second); err != nil {
+		return c, err
+	}
+	if c.RetryAfter < time.Second {
+		return c, fmt.Errorf("RETRY_AFTER must be at least 1s, got %s", c.RetryAfter)
+	}
+
+	c.InputMode = os.Getenv("INPUT_MODE")
+	if c.InputMode == "" {
+		c.InputMode = inputSanitize
+	}
+	if c.InputMode != inputSanitize && c.InputMode != inputReject {
+		return c, fmt.Errorf("INPUT_MODE must be %q or %q, got %q", inputSanitize, inputReject, c.InputMode)
+	}
+
+	if c.ReadOnly, err = envBool("READ_ONLY", false); err != nil {
+		return c, err
+	}
+
+	if c.AdminEndpoints, err = envBool("ADMIN_ENDPOINTS", false); err != nil {
+		return c, err
+	}
+
+	if c.QueryMaxTime, err = envDuration("QUERY_MAX_TIME", 30*time.Second); err != nil {
+		return c, err
+	}
+
+	if c.StreamMaxTime, err = envDuration("STREAM_MAX_TIME", 0); err != nil {
+		return c, err
+	}
+
+	if c.SortTags, err = envBool("SORT_TAGS", true); err != nil {
+		return c, err
+	}
+
+	if c.StrictParams, err = envBool("STRICT_PARAMS", false); err != nil {
+		return c, err
+	}
+
+	if c.ETagWeak, err = envBool("ETAG_WEAK", false); err != nil {
+		return c, err
+	}
+
+	if c.SoftDelete, err = envBool("SOFT_DELETE", false); err != nil {
+		return c, err
+	}
+	c.RedeletePolicy = os.Getenv("REDELETE_POLICY")
+	if c.RedeletePolicy == "" {
+		c.RedeletePolicy = redeleteNotFound
+	}
+	if c.RedeletePolicy != redeleteNotFound && c.RedeletePolicy != redeleteNoop {
+		return c, fmt.Errorf("REDELETE_POLICY must be %q or %q, got %q", redeleteNotFound, redeleteNoop, c.RedeletePolicy)
+	}
+
+	c.IDMismatch = os.Getenv("ID_MISMATCH")
+	if c.IDMismatch == "" {
+		c.IDMismatch = idMismatchReject
+	}
+	if c.IDMismatch != idMismatchReject && c.IDMismatch != idMismatchIgnore {
+		return c, fmt.Errorf("ID_MISMATCH must be %q or %q, got %q", idMismatchReject, idMismatchIgnore, c.IDMismatch)
+	}
+
+	if c.ServerSelectionTimeout, err = envDuration("SERVER_SELECTION_TIMEOUT", 10*time.Second); err != nil {
+		return c, err
+	}
+	if c.ConnectTimeout, err = envDuration("CONNECT_TIMEOUT", 5*time.Second); err != nil {
+		return c, err
+	}
+	if c.ServerSelectionTimeout == 0 || c.ConnectTimeout == 0 {
+		return c, fmt.Errorf("SERVER_SELECTION_TIMEOUT and CONNECT_TIMEOUT must be positive")
+	}
+	if c.ConnectTimeout > c.ServerSelectionTimeout {
+		return c, fmt.Errorf("CONNECT_TIMEOUT (%s) must not exceed SERVER_SELECTION_TIMEOUT (%s)", c.ConnectTimeout, c.ServerSelectionTimeout)
+	}
+
+	if c.RateLimit, err = envInt("RATE_LIMIT", 0); err != nil {
+		return c, err
+	}
+	if c.RateLimitBurst, err = envInt("RATE_LIMIT_BURST", c.RateLimit); err != nil {
+		return c, err
+	}
+	if c.RateLimitSlowStart, err = envDuration("RATE_LIMIT_SLOW_START", 0); err != nil {
+		return c, err
+	}
+	if c.RateLimit < 0 || c.RateLimitBurst < 0 {
+		return c, fmt.Errorf("RATE_LIMIT and RATE_LIMIT_BURST must not be negative")
+	}
+	if c.RateLimit > 0 && c.RateLimitBurst == 0 {
+		return c, fmt.Errorf("RATE_LIMIT_BURST must be positive when RATE_LIMIT is set")
+	}
+
+	if c.StartupGate, err = envBool("STARTUP_GATE", true); err != nil {
+		return c, err
+	}
+
+	if c.PoolMaxSize, err = envInt("POOL_MAX_SIZE", 100); err != nil {
+		return c, err
+	}
+	if c.PoolMaxConnecting, err = envInt("POOL_MAX_CONNECTING", 2); err != nil {
+		return c, err
+	}
+	if c.PoolMaxSize < 1 || c.PoolMaxConnecting < 1 {
+		return c, fmt.Errorf("POOL_MAX_SIZE and POOL_MAX_CONNECTING must be positive")
+	}
+	c.PoolExhausted = os.Getenv("POOL_EXHAUSTED")
+	if c.PoolExhausted == "" {
+		c.PoolExhausted = poolQueue
+	}
+	if c.PoolExhausted != poolQueue && c.PoolExhausted != poolFailFast {
+		return c, fmt.Errorf("POOL_EXHAUSTED must be %q or %q, got %q", poolQueue, poolFailFast, c.PoolExhausted)
+	}
+	if c.PoolWaitTimeout, err = envDuration("POOL_WAIT_TIMEOUT", 100*time.Millisecond); err != nil {
+		return c, err
+	}
+	if c.PoolWaitTimeout == 0 {
+		return c, fmt.Errorf("POOL_WAIT_TIMEOUT must be positive")
+	}
+
+	if c.PartialResults, err = envBool("PARTIAL_RESULTS", false); err != nil {
+		return c, err
+	}
+
+	if c.MaxTags, err = envInt("MAX_TAGS", 100); err != nil {
+		return c, err
+	}
+	if c.MaxTagLength, err = envInt("MAX_TAG_LENGTH", 64); err != nil {
+		return c, err
+	}
+	if c.MaxTags < 0 || c.MaxTagLength < 0 {
+		return c, fmt.Errorf("MAX_TAGS and MAX_TAG_LENGTH must not be negative")
+	}
+
+	c.Timezone = os.Getenv("TIMEZONE")
+	if c.Timezone == "" {
+		c.Timezone = "UTC"
+	}
+	if c.zone, err = loadZone(c.Timezone); err != nil {
+		return c, fmt.Errorf("TIMEZONE: %v", err)
+	}
+
+	return c, nil
+}
+
+func envBool(key string, def bool) (bool, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s: invalid boolean %q", key, v)
+	}
+	return b, nil
+}
+
+func envInt(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid integer %q", key, v)
+	}
+	return n, nil
+}
+
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s: invalid duration %q", key, v)
+	}
+	return d, nil
+}
+
+// envList reads a comma separated list, dropping empty entries.
+func envList(key string, def []string) []string {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// csvColumn maps a CSV column name to the document path it is read from.
+// Paths are looked up segment by segment, so a nested field is written
+// flattened under its dotted name, e.g. "address.city".
+func csvColumn(name string) string {
+	if name == "id" {
+		return "_id"
+	}
+	return name
+}
+
+// csvColumns returns the columns selected by the fields parameter, by
+// default the id and the client-written Person fields the caller can see,
+// in schema order. Each must be a Person field, or a dotted path below one,
+// that the caller is allowed to see.
+func csvColumns(ctx context.Context, fields []string) ([]string, error) {
+	if len(fields) == 0 {
+		for _, f := range personSchema {
+			if !f.Managed && fieldVisible(ctx, f.Name) {
+				fields = append(fields, f.Name)
+			}
+		}
+		return fields, nil
+	}
+	seen := map[string]bool{}
+	var problems []string
+	for _, f := range fields {
+		top, _, _ := strings.Cut(f, ".")
+		switch {
+		case !isPersonField(top) || strings.HasSuffix(f, "."):
+			problems = append(problems, fmt.Sprintf("%q is not a field", f))
+		case !fieldVisible(ctx, top):
+			problems = append(problems, fmt.Sprintf("%q is not available", f))
+		case seen[f]:
+			problems = append(problems, fmt.Sprintf("%q is repeated", f))
+		}
+		seen[f] = true
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid fields: %s", strings.Join(problems, "; "))
+	}
+	return fields, nil
+}
+
+// ExportPeopleCSV streams every person matching the GET /people filters as
+// CSV, with a header row naming the columns. It isn't paginated; rows are
+// written as the cursor yields them, and a query that fails partway cuts
+// the connection.
+func ExportPeopleCSV(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling GET request for /people/export.csv")
+	values := r.URL.Query()
+	q, err := parsePeopleQuery(values)
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	columns, err := csvColumns(r.Context(), splitParams(values["fields"]))
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter, err := q.filter(r.Context())
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sort, err := q.sortSpec(r.Context())
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Project whole top-level fields: "address" and "address.city" in one
+	// projection would be a path collision.
+	projection := bson.M{}
+	for _, c := range columns {
+		top, _, _ := strings.Cut(csvColumn(c), ".")
+		projection[top] = 1
+	}
+	if projection["_id"] == nil {
+		projection["_id"] = 0
+	}
+
+	ctx := r.Context()
+	collection := client.Database(Database).Collection(Collection)
+	cur, err := collection.Find(ctx, liveFilter(filter), streamFindOptions(ctx).SetSort(sort).SetProjection(projection))
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	defer cur.Close(context.Background())
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="people.csv"`)
+	out := csv.NewWriter(w)
+	out.Write(columns)
+
+	loc := responseZone(ctx)
+	row := make([]string, len(columns))
+	n := 0
+	for cur.Next(ctx) {
+		for i, c := range columns {
+			rv, err := cur.Current.LookupErr(strings.Split(csvColumn(c), ".")...)
+			if err != nil {
+				row[i] = ""
+				continue
+			}
+			row[i] = csvValue(rv, loc)
+		}
+		if err := out.Write(row); err != nil {
+			log.Println("Client went away during CSV export:", err)
+			return
+		}
+		if n++; n%100 == 0 {
+			out.Flush()
+		}
+	}
+	if err := cur.Err(); err != nil {
+		abortStream("CSV export", err)
+	}
+	out.Flush()
+}
+
+// csvValue formats one cell, with dates in loc. Array elements are joined
+// with ";" and embedded documents are written as relaxed Extended JSON.
+func csvValue(rv bson.RawValue, loc *time.Location) string {
+	switch rv.Type {
+	case bsontype.Null, bsontype.Undefined:
+		return ""
+	case bsontype.String:
+		return rv.StringValue()
+	case bsontype.ObjectID:
+		return rv.ObjectID().Hex()
+	case bsontype.Int32:
+		return strconv.FormatInt(int64(rv.Int32()), 10)
+	case bsontype.Int64:
+		return strconv.FormatInt(rv.Int64(), 10)
+	case bsontype.Double:
+		return strconv.FormatFloat(rv.Double(), 'f', -1, 64)
+	case bsontype.Boolean:
+		return strconv.FormatBool(rv.Boolean())
+	case bsontype.DateTime:
+		return rv.Time().In(loc).Format(time.RFC3339Nano)
+	case bsontype.Array:
+		elems, err := rv.Array().Values()
+		if err != nil {
+			return ""
+		}
+		parts := make([]string, len(elems))
+		for i, e := range elems {
+			parts[i] = csvValue(e, loc)
+		}
+		return strings.Join(parts, ";")
+	case bsontype.EmbeddedDocument:
+		doc, err := bson.MarshalExtJSON(rv.Document(), false, false)
+		if err != nil {
+			return ""
+		}
+		return string(doc)
+	}
+	return rv.String()
+}
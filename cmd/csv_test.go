@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCSVColumns(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.RoleFields = map[string][]string{"viewer": {"name"}}
+	viewer := context.WithValue(context.Background(), principalKey{}, Principal{ID: "v", Role: "viewer"})
+
+	// By default the columns are the fields clients write, less those the
+	// caller can't see.
+	if columns, err := csvColumns(context.Background(), nil); err != nil ||
+		!reflect.DeepEqual(columns, []string{"id", "name", "age", "address", "email", "tags"}) {
+		t.Errorf("default columns = %v, %v; want the id and the fields clients write", columns, err)
+	}
+	if columns, err := csvColumns(viewer, nil); err != nil || !reflect.DeepEqual(columns, []string{"id", "name"}) {
+		t.Errorf("default columns for a viewer = %v, %v; want id and name", columns, err)
+	}
+
+	tests := []struct {
+		ctx    context.Context
+		fields []string
+		want   string // substring of the error, or "" for success
+	}{
+		{context.Background(), []string{"name", "address.city"}, ""},
+		{context.Background(), []string{"nope"}, `"nope" is not a field`},
+		{context.Background(), []string{"address."}, `"address." is not a field`},
+		{context.Background(), []string{"name", "name"}, `"name" is repeated`},
+		{viewer, []string{"id", "name"}, ""},
+		{viewer, []string{"age"}, `"age" is not available`},
+		{viewer, []string{"address.city"}, `"address.city" is not available`},
+		{context.Background(), []string{"created_at", "deleted_at"}, ""},
+	}
+	for _, tt := range tests {
+		_, err := csvColumns(tt.ctx, tt.fields)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("csvColumns(%v) = %v, want no error", tt.fields, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("csvColumns(%v) = %v, want an error containing %s", tt.fields, err, tt.want)
+		}
+	}
+}
+
+func TestCSVValue(t *testing.T) {
+	id := primitive.NewObjectID()
+	when := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	raw, err := bson.Marshal(bson.D{
+		{Key: "null", Value: nil},
+		{Key: "string", Value: "Ann"},
+		{Key: "id", Value: id},
+		{Key: "int32", Value: int32(7)},
+		{Key: "int64", Value: int64(8)},
+		{Key: "double", Value: 1.5},
+		{Key: "bool", Value: true},
+		{Key: "date", Value: when},
+		{Key: "tags", Value: bson.A{"a", "b", int32(3)}},
+		{Key: "doc", Value: bson.D{{Key: "city", Value: "Paris"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := map[string]string{
+		"null":   "",
+		"string": "Ann",
+		"id":     id.Hex(),
+		"int32":  "7",
+		"int64":  "8",
+		"double": "1.5",
+		"bool":   "true",
+		"date":   "2024-03-01T17:30:00+05:30",
+		"tags":   "a;b;3",
+		"doc":    `{"city":"Paris"}`,
+	}
+	for key, want := range tests {
+		if got := csvValue(bson.Raw(raw).Lookup(key), kolkata); got != want {
+			t.Errorf("csvValue(%s) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestExportPeopleCSVAbortsOnCursorError(t *testing.T) {
+	runMock(t, "getMore fails", func(mt *mtest.T) {
+		// The first batch is written out, then fetching the next one fails.
+		first := bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "name", Value: "Ann"}}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(42, Database+"."+Collection, mtest.FirstBatch, first),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 50, Name: "MaxTimeMSExpired", Message: "operation exceeded time limit"}),
+		)
+		defer func() {
+			if r := recover(); r != http.ErrAbortHandler {
+				mt.Errorf("ExportPeopleCSV recovered %v, want http.ErrAbortHandler so the response is cut off", r)
+			}
+		}()
+		ExportPeopleCSV(httptest.NewRecorder(), httptest.NewRequest("GET", "/people/export.csv?fields=id,name", nil))
+	})
+}
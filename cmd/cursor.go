@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// pageCursor is the decoded form of an after cursor: the sort it was issued
+// for, as "+field" or "-field" per key, and the last document's value for
+// each key. A nil value means the field was null or missing.
+type pageCursor struct {
+	Keys   []string      `bson:"k"`
+	Values []interface{} `bson:"v"`
+}
+
+// encodeCursor returns the cursor for the page that follows last, a raw
+// document returned by a find sorted by sort.
+func encodeCursor(sort bson.D, last bson.Raw) (string, error) {
+	c := pageCursor{Keys: cursorKeys(sort)}
+	for _, e := range sort {
+		var v interface{}
+		if rv, err := last.LookupErr(e.Key); err == nil && rv.Type != bsontype.Null {
+			if err := rv.Unmarshal(&v); err != nil {
+				return "", err
+			}
+		}
+		c.Values = append(c.Values, v)
+	}
+	raw, err := bson.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// decodeCursor checks that after was issued for sort and returns the filter
+// matching the documents that come after it. A cursor past the last
+// document is valid and simply matches nothing.
+func decodeCursor(after string, sort bson.D) (bson.M, error) {
+	invalid := fmt.Errorf("invalid after cursor")
+	raw, err := base64.RawURLEncoding.DecodeString(after)
+	if err != nil {
+		return nil, invalid
+	}
+	var c pageCursor
+	if err := bson.Unmarshal(raw, &c); err != nil {
+		return nil, invalid
+	}
+	keys := cursorKeys(sort)
+	if len(c.Keys) != len(keys) || len(c.Values) != len(keys) {
+		return nil, fmt.Errorf("after cursor does not match the requested sort")
+	}
+	for i, key := range keys {
+		if c.Keys[i] != key {
+			return nil, fmt.Errorf("after cursor does not match the requested sort")
+		}
+		if !cursorValueOK(sort[i].Key, c.Values[i]) {
+			return nil, invalid
+		}
+	}
+	return keysetFilter(sort, c.Values), nil
+}
+
+func cursorKeys(sort bson.D) []string {
+	keys := make([]string, len(sort))
+	for i, e := range sort {
+		if e.Value == -1 {
+			keys[i] = "-" + e.Key
+		} else {
+			keys[i] = "+" + e.Key
+		}
+	}
+	return keys
+}
+
+// cursorValueOK reports whether v is a value field can hold.
+func cursorValueOK(field string, v interface{}) bool {
+	switch v.(type) {
+	case nil:
+		return field != "_id"
+	case primitive.ObjectID:
+		return field == "_id"
+	case string:
+		return field == "name" || field == "address"
+	case int32, int64, float64:
+		return field == "age"
+	case primitive.DateTime:
+		return field == "deleted_at"
+	}
+	return false
+}
+
+// keysetFilter matches the documents that sort strictly after values. Each
+// branch of the $or holds the leading keys equal and moves past the next
+// one. Null and missing values sort before everything else, so they need
+// their own conditions: nothing sorts below null in descending order.
+func keysetFilter(sort bson.D, values []interface{}) bson.M {
+	var branches []bson.M
+	for i, e := range sort {
+		branch := bson.M{}
+		for j := 0; j < i; j++ {
+			branch[sort[j].Key] = values[j]
+		}
+		v := values[i]
+		switch {
+		case e.Value != -1 && v == nil:
+			branch[e.Key] = bson.M{"$ne": nil}
+		case e.Value != -1:
+			branch[e.Key] = bson.M{"$gt": v}
+		case v == nil:
+			continue
+		default:
+			branch["$or"] = []bson.M{{e.Key: bson.M{"$lt": v}}, {e.Key: nil}}
+		}
+		branches = append(branches, branch)
+	}
+	return bson.M{"$or": branches}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := primitive.NewObjectID()
+	sort := bson.D{{Key: "age", Value: -1}, {Key: "_id", Value: 1}}
+	last, err := bson.Marshal(bson.M{"_id": id, "name": "Ann", "age": int32(30)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := encodeCursor(sort, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := decodeCursor(after, sort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := keysetFilter(sort, []interface{}{int32(30), id}); !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeCursor = %v, want %v", got, want)
+	}
+
+	for _, other := range []bson.D{
+		{{Key: "age", Value: 1}, {Key: "_id", Value: 1}},
+		{{Key: "_id", Value: 1}},
+	} {
+		if _, err := decodeCursor(after, other); err == nil || !strings.Contains(err.Error(), "does not match the requested sort") {
+			t.Errorf("decodeCursor with sort %v: error = %v, want a sort mismatch", other, err)
+		}
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	sort := bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}
+	forged := func(values ...interface{}) string {
+		raw, err := bson.Marshal(pageCursor{Keys: cursorKeys(sort), Values: values})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	for _, after := range []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("not bson")),
+		// Operators can't be smuggled in as values.
+		forged(bson.M{"$gt": ""}, primitive.NewObjectID()),
+		forged("Ann", "not an ObjectID"),
+		forged("Ann", nil),
+	} {
+		if _, err := decodeCursor(after, sort); err == nil || err.Error() != "invalid after cursor" {
+			t.Errorf("decodeCursor(%q) error = %v, want invalid after cursor", after, err)
+		}
+	}
+}
+
+func TestKeysetFilter(t *testing.T) {
+	id := primitive.NewObjectID()
+	tests := []struct {
+		name   string
+		sort   bson.D
+		values []interface{}
+		want   bson.M
+	}{
+		{
+			"ascending",
+			bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}},
+			[]interface{}{"Ann", id},
+			bson.M{"$or": []bson.M{
+				{"name": bson.M{"$gt": "Ann"}},
+				{"name": "Ann", "_id": bson.M{"$gt": id}},
+			}},
+		},
+		{
+			"ascending from null",
+			bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}},
+			[]interface{}{nil, id},
+			bson.M{"$or": []bson.M{
+				{"name": bson.M{"$ne": nil}},
+				{"name": nil, "_id": bson.M{"$gt": id}},
+			}},
+		},
+		{
+			// Nulls come last in descending order, so they follow 30.
+			"descending",
+			bson.D{{Key: "age", Value: -1}, {Key: "_id", Value: 1}},
+			[]interface{}{int32(30), id},
+			bson.M{"$or": []bson.M{
+				{"$or": []bson.M{{"age": bson.M{"$lt": int32(30)}}, {"age": nil}}},
+				{"age": int32(30), "_id": bson.M{"$gt": id}},
+			}},
+		},
+		{
+			"descending from null",
+			bson.D{{Key: "age", Value: -1}, {Key: "_id", Value: 1}},
+			[]interface{}{nil, id},
+			bson.M{"$or": []bson.M{
+				{"age": nil, "_id": bson.M{"$gt": id}},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		if got := keysetFilter(tt.sort, tt.values); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: keysetFilter = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fieldChange is the old and new value of a changed field.
+type fieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// personDiff describes how one person differs from another, keyed by JSON
+// field name. A field is added or removed when it is only set on one side.
+type personDiff struct {
+	Added   map[string]interface{} `json:"added"`
+	Removed map[string]interface{} `json:"removed"`
+	Changed map[string]fieldChange `json:"changed"`
+}
+
+// diffPeople compares every Person field except the id. A field counts as
+// set when the stored document has it, so a field stored even when zero,
+// such as age, changes from 5 to 0 rather than being removed.
+func diffPeople(from, to Person) personDiff {
+	d := personDiff{
+		Added:   map[string]interface{}{},
+		Removed: map[string]interface{}{},
+		Changed: map[string]fieldChange{},
+	}
+	a, b := reflect.ValueOf(from), reflect.ValueOf(to)
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" || name == "id" {
+			continue
+		}
+		old, cur := a.Field(i), b.Field(i)
+		had, has := storedField(t.Field(i), old), storedField(t.Field(i), cur)
+		switch {
+		case !had && !has:
+		case !had:
+			d.Added[name] = cur.Interface()
+		case !has:
+			d.Removed[name] = old.Interface()
+		case !reflect.DeepEqual(old.Interface(), cur.Interface()):
+			d.Changed[name] = fieldChange{Old: old.Interface(), New: cur.Interface()}
+		}
+	}
+	return d
+}
+
+// storedField reports whether a field with value v is written to the
+// stored document. As in the bson encoder, only omitempty fields are left
+// out, and only when empty.
+func storedField(field reflect.StructField, v reflect.Value) bool {
+	_, opts, _ := strings.Cut(field.Tag.Get("bson"), ",")
+	if !strings.Contains(opts, "omitempty") {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		return v.Len() > 0
+	}
+	return !v.IsZero()
+}
+
+// personChanged reports whether an update changed anything a client set.
+// updated_at and updated_by are stamped by every write, so they don't
+// count.
+func personChanged(before, after Person) bool {
+	d := diffPeople(before, after)
+	delete(d.Added, "updated_at")
+	delete(d.Added, "updated_by")
+	delete(d.Changed, "updated_at")
+	delete(d.Changed, "updated_by")
+	return len(d.Added)+len(d.Removed)+len(d.Changed) > 0
+}
+
+// setUpdateCounts reports the outcome of a single-person update in the
+// X-Matched-Count and X-Modified-Count headers, as UpdateMany responses do
+// in their body. A person that matched but didn't change has a modified
+// count of 0.
+func setUpdateCounts(w http.ResponseWriter, matched int64, changed bool) {
+	modified := 0
+	if changed {
+		modified = 1
+	}
+	w.Header().Set("X-Matched-Count", strconv.FormatInt(matched, 10))
+	w.Header().Set("X-Modified-Count", strconv.Itoa(modified))
+}
+
+// DiffPeople compares the person in the path with the one named by the
+// against parameter: added fields are set only on the other person.
+func DiffPeople(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling GET request for /people/id/diff")
+	ids := map[string]string{"id": mux.Vars(r)["id"], "against": r.URL.Query().Get("against")}
+	if ids["against"] == "" {
+		handleClientError(w, http.StatusBadRequest, "against is required")
+		return
+	}
+
+	people := map[string]Person{}
+	for _, key := range []string{"id", "against"} {
+		filter, err := personFilter(r.Context(), key, ids[key])
+		if err != nil {
+			handleClientError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		person, err := findPerson(r.Context(), filter)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			handleClientError(w, http.StatusNotFound, fmt.Sprintf("person %q (%s) not found", ids[key], key))
+			return
+		}
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+		people[key] = person
+	}
+
+	writeJSON(w, r, http.StatusOK, diffPeople(people["id"], people["against"]))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDiffPeople(t *testing.T) {
+	from := Person{ID: primitive.NewObjectID(), Name: "Ann", Age: 30, Address: "1 Main St", Tags: []string{"a"}}
+	to := Person{ID: primitive.NewObjectID(), Name: "Ann", Age: 31, Email: "ann@example.com", Tags: []string{"a"}}
+
+	got := diffPeople(from, to)
+	want := personDiff{
+		Added:   map[string]interface{}{"email": "ann@example.com"},
+		Removed: map[string]interface{}{"address": "1 Main St"},
+		Changed: map[string]fieldChange{"age": {Old: 30, New: 31}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffPeople = %+v, want %+v", got, want)
+	}
+
+	same := diffPeople(from, from)
+	if len(same.Added)+len(same.Removed)+len(same.Changed) != 0 {
+		t.Errorf("diffPeople of a person with itself = %+v", same)
+	}
+
+	// Age is stored even when 0, so 5 to 0 is a change, not a removal.
+	got = diffPeople(Person{Name: "Ann", Age: 5}, Person{Name: "Ann"})
+	if want := (personDiff{
+		Added:   map[string]interface{}{},
+		Removed: map[string]interface{}{},
+		Changed: map[string]fieldChange{"age": {Old: 5, New: 0}},
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffPeople from age 5 to 0 = %+v, want %+v", got, want)
+	}
+	// An empty tag list is stored like no tags at all.
+	if got := diffPeople(Person{Name: "Ann"}, Person{Name: "Ann", Tags: []string{}}); len(got.Added) != 0 {
+		t.Errorf("diffPeople from no tags to an empty list = %+v, want no change", got)
+	}
+}
+
+func TestPersonChanged(t *testing.T) {
+	t1, t2 := time.Unix(1700000000, 0), time.Unix(1700000500, 0)
+	before := Person{Name: "Ann", Age: 30, UpdatedAt: &t1, UpdatedBy: "alice"}
+
+	restamped := before
+	restamped.UpdatedAt, restamped.UpdatedBy = &t2, "bob"
+	if personChanged(before, restamped) {
+		t.Error("a write that only restamped updated_at and updated_by counts as a change")
+	}
+
+	older := before
+	older.Age = 31
+	if !personChanged(before, older) {
+		t.Error("a new age doesn't count as a change")
+	}
+
+	untagged := before
+	untagged.Tags = []string{"vip"}
+	if !personChanged(untagged, before) {
+		t.Error("removing the tags doesn't count as a change")
+	}
+}
+
+func TestSetUpdateCounts(t *testing.T) {
+	for _, tt := range []struct {
+		changed  bool
+		modified string
+	}{{true, "1"}, {false, "0"}} {
+		rec := httptest.NewRecorder()
+		setUpdateCounts(rec, 1, tt.changed)
+		if got := rec.Header().Get("X-Matched-Count"); got != "1" {
+			t.Errorf("X-Matched-Count = %q, want 1", got)
+		}
+		if got := rec.Header().Get("X-Modified-Count"); got != tt.modified {
+			t.Errorf("changed=%v: X-Modified-Count = %q, want %s", tt.changed, got, tt.modified)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// draining is set when shutdown begins. From then on new requests get a
+// 503 and readiness fails, so load balancers move traffic elsewhere.
+var draining atomic.Bool
+
+// streamPaths are the endpoints whose responses can run for minutes. On
+// shutdown they get config.StreamDrainTimeout on top of the time given to
+// ordinary requests.
+var streamPaths = map[string]bool{
+	"/people/stream":     true,
+	"/people/export.csv": true,
+	"/admin/recompute":   true,
+}
+
+// inflight counts the requests being served, streams apart.
+var inflight struct {
+	requests, streams atomic.Int64
+}
+
+// drainMiddleware refuses new requests once draining is set and counts the
+// ones in flight. Health probes are always answered.
+func drainMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/health/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		counter := &inflight.requests
+		if streamPaths[r.URL.Path] {
+			counter = &inflight.streams
+		}
+		counter.Add(1)
+		defer counter.Add(-1)
+		if draining.Load() {
+			w.Header().Set("Connection", "close")
+			writeError(w, http.StatusServiceUnavailable, "the service is shutting down")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// waitIdle polls counter until it reaches zero or timeout passes, and
+// returns what is still in flight.
+func waitIdle(counter *atomic.Int64, timeout time.Duration) int64 {
+	deadline := time.Now().Add(timeout)
+	for {
+		n := counter.Load()
+		if n <= 0 || !time.Now().Before(deadline) {
+			return n
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// shutdownServer stops srv in phases. Shutdown closes the listeners at
+// once, and draining turns away requests on connections already open.
+// Ordinary requests then get requestTimeout to finish, and streams
+// streamTimeout more, before shutdownCtx is cancelled to close the rest
+// and stop the background workers, which get workerTimeout. Change streams
+// only end at that cancellation, so srv.Shutdown's own deadline is the sum
+// of all three phases: it must not give up on connections before the
+// workers serving them were told to stop.
+func shutdownServer(srv *http.Server, requestTimeout, streamTimeout, workerTimeout time.Duration) {
+	log.Println("Shutting down: no longer accepting connections")
+	draining.Store(true)
+	serverCtx, cancel := context.WithTimeout(context.Background(), requestTimeout+streamTimeout+workerTimeout)
+	defer cancel()
+	shutdownDone := make(chan error, 1)
+	go func() {
+		shutdownDone <- srv.Shutdown(serverCtx)
+	}()
+
+	if n := waitIdle(&inflight.requests, requestTimeout); n > 0 {
+		log.Printf("%d requests did not finish within %s", n, requestTimeout)
+	} else {
+		log.Println("Requests drained")
+	}
+	if n := inflight.streams.Load(); n > 0 {
+		log.Printf("Waiting up to %s for %d streams", streamTimeout, n)
+		if n := waitIdle(&inflight.streams, streamTimeout); n > 0 {
+			log.Printf("Closing %d streams still open", n)
+		} else {
+			log.Println("Streams drained")
+		}
+	}
+
+	stuck := stopWorkers(workerTimeout)
+	if len(stuck) > 0 {
+		log.Println("Workers did not stop in time:", strings.Join(stuck, ", "))
+	}
+	if err := <-shutdownDone; err != nil {
+		log.Println("Error shutting down server:", err)
+		srv.Close()
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDrainMiddleware(t *testing.T) {
+	defer draining.Store(draining.Load())
+	draining.Store(false)
+
+	var requests, streams int64
+	handler := drainMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests, streams = inflight.requests.Load(), inflight.streams.Load()
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	serve("/people")
+	if requests != 1 || streams != 0 {
+		t.Errorf("during GET /people: %d requests and %d streams in flight, want 1 and 0", requests, streams)
+	}
+	serve("/people/stream")
+	if requests != 0 || streams != 1 {
+		t.Errorf("during GET /people/stream: %d requests and %d streams in flight, want 0 and 1", requests, streams)
+	}
+	if n := inflight.requests.Load() + inflight.streams.Load(); n != 0 {
+		t.Errorf("%d requests still counted after finishing", n)
+	}
+
+	draining.Store(true)
+	rec := serve("/people")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Connection") != "close" {
+		t.Errorf("while draining: status %d, Connection %q; want 503, close", rec.Code, rec.Header().Get("Connection"))
+	}
+	if rec := serve("/health/live"); rec.Code != http.StatusOK {
+		t.Errorf("health probe while draining: status %d, want 200", rec.Code)
+	}
+}
+
+func TestWaitIdle(t *testing.T) {
+	var counter atomic.Int64
+	if n := waitIdle(&counter, time.Second); n != 0 {
+		t.Errorf("waitIdle on an idle counter = %d", n)
+	}
+
+	counter.Store(2)
+	if n := waitIdle(&counter, 10*time.Millisecond); n != 2 {
+		t.Errorf("waitIdle past its timeout = %d, want 2", n)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		counter.Store(0)
+	}()
+	if n := waitIdle(&counter, time.Minute); n != 0 {
+		t.Errorf("waitIdle after the requests finished = %d, want 0", n)
+	}
+}
+
+func TestShutdownServerStreamGrace(t *testing.T) {
+	defer draining.Store(draining.Load())
+	defer func(ctx context.Context, cancel context.CancelFunc) {
+		shutdownCtx, cancelShutdown = ctx, cancel
+	}(shutdownCtx, cancelShutdown)
+	shutdownCtx, cancelShutdown = context.WithCancel(context.Background())
+	draining.Store(false)
+	stop := shutdownCtx
+
+	// The stream behaves like StreamPeople's: tracked, and open until
+	// shutdownCtx is cancelled.
+	opened := make(chan struct{})
+	closed := make(chan time.Time, 1)
+	handler := drainMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer trackWorker("people-stream")()
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		close(opened)
+		<-stop.Done()
+		closed <- time.Now()
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(ln)
+	res, err := http.Get("http://" + ln.Addr().String() + "/people/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	<-opened
+
+	const requestTimeout, streamTimeout = 10 * time.Millisecond, 200 * time.Millisecond
+	start := time.Now()
+	shutdownServer(srv, requestTimeout, streamTimeout, time.Second)
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("shutdown took %s with the stream closed after its grace", took)
+	}
+	select {
+	case at := <-closed:
+		if grace := at.Sub(start); grace < streamTimeout {
+			t.Errorf("stream closed %s into shutdown, before its %s grace ran out", grace, streamTimeout)
+		}
+	default:
+		t.Error("the stream was still open after shutdown")
+	}
+	if n := inflight.streams.Load(); n != 0 {
+		t.Errorf("%d streams still counted after shutdown", n)
+	}
+}
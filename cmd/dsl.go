@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The where DSL is a restricted MongoDB filter, e.g.
+//
+//	{"$or": [{"age": {"$gte": 65}}, {"tags": {"$in": ["vip"]}}]}
+//
+// Top-level keys are allow-listed fields or the logical operators $and,
+// $or and $nor, which take an array of nested filters. A field maps to a
+// literal (equality) or to an object of comparison operators. Queries are
+// bounded by config.QueryMaxConditions, QueryMaxDepth and QueryMaxIn, and
+// may only use fields the caller can see.
+
+var comparisonOps = map[string]bool{
+	"$eq": true, "$ne": true,
+	"$gt": true, "$gte": true, "$lt": true, "$lte": true,
+	"$in": true, "$nin": true,
+	"$exists": true,
+}
+
+var logicalOps = map[string]bool{"$and": true, "$or": true, "$nor": true}
+
+// compileWhere validates a DSL query and returns the equivalent filter.
+func compileWhere(ctx context.Context, raw json.RawMessage) (bson.M, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("where: malformed JSON: %v", err)
+	}
+	c := whereCompiler{ctx: ctx}
+	return c.filter(doc, 1)
+}
+
+type whereCompiler struct {
+	ctx        context.Context
+	conditions int
+}
+
+func (c *whereCompiler) filter(v interface{}, depth int) (bson.M, error) {
+	if depth > config.QueryMaxDepth {
+		return nil, fmt.Errorf("where: nesting exceeds the maximum depth of %d", config.QueryMaxDepth)
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("where: filters must be objects")
+	}
+
+	out := bson.M{}
+	for key, val := range obj {
+		if logicalOps[key] {
+			list, ok := val.([]interface{})
+			if !ok || len(list) == 0 {
+				return nil, fmt.Errorf("where: %s takes a non-empty array of filters", key)
+			}
+			clauses := make([]bson.M, len(list))
+			for i, item := range list {
+				clause, err := c.filter(item, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				clauses[i] = clause
+			}
+			out[key] = clauses
+			continue
+		}
+		if !filterFields[key] {
+			return nil, fmt.Errorf("where: unknown field or operator %q", key)
+		}
+		if !fieldVisible(c.ctx, key) {
+			return nil, fmt.Errorf("where: field %q is not available", key)
+		}
+		cond, err := c.condition(key, val)
+		if err != nil {
+			return nil, err
+		}
+		out[key] = cond
+	}
+	return out, nil
+}
+
+// condition compiles the value of a field: a literal or an operator object.
+func (c *whereCompiler) condition(field string, v interface{}) (interface{}, error) {
+	ops, ok := v.(map[string]interface{})
+	if !ok {
+		if err := c.count(); err != nil {
+			return nil, err
+		}
+		return literal(field, v)
+	}
+
+	out := bson.M{}
+	for op, arg := range ops {
+		if !comparisonOps[op] {
+			return nil, fmt.Errorf("where: unsupported operator %q on %q", op, field)
+		}
+		if err := c.count(); err != nil {
+			return nil, err
+		}
+		switch op {
+		case "$exists":
+			b, ok := arg.(bool)
+			if !ok {
+				return nil, fmt.Errorf("where: $exists on %q takes a boolean", field)
+			}
+			out[op] = b
+		case "$in", "$nin":
+			list, ok := arg.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("where: %s on %q takes an array", op, field)
+			}
+			if len(list) > config.QueryMaxIn {
+				return nil, fmt.Errorf("where: %s on %q has %d values, more than the maximum of %d", op, field, len(list), config.QueryMaxIn)
+			}
+			values := make([]interface{}, len(list))
+			for i, item := range list {
+				lit, err := literal(field, item)
+				if err != nil {
+					return nil, err
+				}
+				values[i] = lit
+			}
+			out[op] = values
+		default:
+			lit, err := literal(field, arg)
+			if err != nil {
+				return nil, err
+			}
+			out[op] = lit
+		}
+	}
+	return out, nil
+}
+
+func (c *whereCompiler) count() error {
+	if c.conditions++; c.conditions > config.QueryMaxConditions {
+		return fmt.Errorf("where: more than the maximum of %d conditions", config.QueryMaxConditions)
+	}
+	return nil
+}
+
+// literal converts a JSON scalar to a filter value of field's schema type:
+// a number for integer fields, a string for string fields and the elements
+// of string arrays. null matches a missing field, whatever its type.
+// Objects and arrays are rejected so clients can't smuggle in operators.
+func literal(field string, v interface{}) (interface{}, error) {
+	typ, _ := personFieldType(field)
+	switch t := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if typ == "string" || typ == "array<string>" {
+			return t, nil
+		}
+	case json.Number:
+		if typ == "integer" {
+			if n, err := t.Int64(); err == nil {
+				return n, nil
+			}
+			return t.Float64()
+		}
+	}
+	if typ == "integer" {
+		return nil, fmt.Errorf("where: value for %q must be a number or null", field)
+	}
+	return nil, fmt.Errorf("where: value for %q must be a string or null", field)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCompileWhere(t *testing.T) {
+	got, err := compileWhere(context.Background(), json.RawMessage(`{"$or": [{"age": {"$gte": 65}}, {"tags": {"$in": ["vip"]}}], "name": "Ann"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{
+		"$or":  []bson.M{{"age": bson.M{"$gte": int64(65)}}, {"tags": bson.M{"$in": []interface{}{"vip"}}}},
+		"name": "Ann",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("compileWhere = %v, want %v", got, want)
+	}
+}
+
+func TestCompileWhereRejects(t *testing.T) {
+	tests := []struct {
+		where, err string
+	}{
+		{`{"salary": 1}`, `unknown field or operator "salary"`},
+		{`{"age": {"$where": "1"}}`, `unsupported operator "$where"`},
+		{`{"name": {"$eq": {"$ne": 1}}}`, `value for "name" must be a string or null`},
+		{`{"age": {"$gte": "abc"}}`, `value for "age" must be a number or null`},
+		{`{"age": true}`, `value for "age" must be a number or null`},
+		{`{"name": 42}`, `value for "name" must be a string or null`},
+		{`{"tags": {"$in": ["a", 1]}}`, `value for "tags" must be a string or null`},
+		{`{"$and": []}`, `$and takes a non-empty array`},
+		{`[1]`, `filters must be objects`},
+		{`{"age": `, `malformed JSON`},
+	}
+	for _, tt := range tests {
+		_, err := compileWhere(context.Background(), json.RawMessage(tt.where))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("compileWhere(%s) error = %v, want it to contain %q", tt.where, err, tt.err)
+		}
+	}
+}
+
+func TestCompileWhereLimits(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.QueryMaxDepth, config.QueryMaxConditions, config.QueryMaxIn = 2, 3, 2
+
+	tests := []struct {
+		where string
+		err   string
+	}{
+		{`{"$or": [{"age": 1}]}`, ""},
+		{`{"$or": [{"$and": [{"age": 1}]}]}`, "maximum depth of 2"},
+		{`{"name": "a", "age": {"$gt": 1, "$lt": 9}}`, ""},
+		{`{"name": "a", "age": {"$gt": 1, "$lt": 9}, "email": "b"}`, "maximum of 3 conditions"},
+		// Conditions are counted across branches.
+		{`{"$or": [{"name": "a"}, {"name": "b"}, {"name": "c"}, {"name": "d"}]}`, "maximum of 3 conditions"},
+		{`{"tags": {"$in": ["a", "b"]}}`, ""},
+		{`{"tags": {"$nin": ["a", "b", "c"]}}`, "3 values, more than the maximum of 2"},
+	}
+	for _, tt := range tests {
+		_, err := compileWhere(context.Background(), json.RawMessage(tt.where))
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("compileWhere(%s): %v", tt.where, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("compileWhere(%s) error = %v, want it to contain %q", tt.where, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// apiStrictOperation matches the command or operator named in the server's
+// strict-mode rejections, e.g. "Provided apiStrict:true, but the command
+// collStats is not in API Version 1" or "$percentile is not allowed with
+// 'apiStrict: true' in API Version 1".
+var apiStrictOperation = regexp.MustCompile(`(?:the command (\S+)|(\$\w+) is not allowed)`)
+
+// apiStrictRejection reports whether err is the server refusing a command
+// or operator that isn't part of Stable API version 1 while strict mode is
+// on, and which operation it names, when the message says.
+func apiStrictRejection(err error) (operation string, ok bool) {
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) {
+		return "", false
+	}
+	if cmdErr.Code != 323 && cmdErr.Name != "APIStrictError" {
+		return "", false
+	}
+	if m := apiStrictOperation.FindStringSubmatch(cmdErr.Message); m != nil {
+		if m[1] != "" {
+			return m[1], true
+		}
+		return m[2], true
+	}
+	return "", true
+}
+
+// isMaxTimeExpired reports whether the server stopped a query because it
+// ran past its maxTimeMS.
+func isMaxTimeExpired(err error) bool {
+	var se mongo.ServerError
+	return errors.As(err, &se) && se.HasErrorCode(50) // MaxTimeMSExpired
+}
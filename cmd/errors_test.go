@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestAPIStrictRejection(t *testing.T) {
+	tests := []struct {
+		err       error
+		operation string
+		ok        bool
+	}{
+		{mongo.CommandError{Code: 323, Name: "APIStrictError",
+			Message: "Provided apiStrict:true, but the command collStats is not in API Version 1"}, "collStats", true},
+		{mongo.CommandError{Code: 323,
+			Message: "$percentile is not allowed with 'apiStrict: true' in API Version 1"}, "$percentile", true},
+		// Found through wrapping, and without a recognizable message.
+		{fmt.Errorf("aggregate: %w", mongo.CommandError{Name: "APIStrictError", Message: "something new"}), "", true},
+		{mongo.CommandError{Code: 50, Name: "MaxTimeMSExpired", Message: "operation exceeded time limit"}, "", false},
+		{errors.New("the command collStats is not allowed"), "", false},
+		{nil, "", false},
+	}
+	for _, tt := range tests {
+		operation, ok := apiStrictRejection(tt.err)
+		if operation != tt.operation || ok != tt.ok {
+			t.Errorf("apiStrictRejection(%v) = %q, %v; want %q, %v", tt.err, operation, ok, tt.operation, tt.ok)
+		}
+	}
+}
+
+func TestHandleErrorMaxTimeExpired(t *testing.T) {
+	for _, err := range []error{
+		mongo.CommandError{Code: 50, Name: "MaxTimeMSExpired", Message: "operation exceeded time limit"},
+		fmt.Errorf("find: %w", mongo.CommandError{Code: 50}),
+	} {
+		rec := httptest.NewRecorder()
+		handleError(rec, err)
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Errorf("handleError(%v): status %d, want 504", err, rec.Code)
+		}
+	}
+	if isMaxTimeExpired(mongo.CommandError{Code: 11600, Name: "InterruptedAtShutdown"}) {
+		t.Error("isMaxTimeExpired matched another server error")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// errETagMismatch aborts a write whose If-Match precondition failed.
+var errETagMismatch = errors.New("person does not match If-Match")
+
+// personETag returns the entity tag of the representation writeJSON would
+// send for p: a hash of the body, so it changes with the caller's visible
+// fields and with ext_json. It is weak (W/"...") when config.ETagWeak is
+// set and strong otherwise.
+func personETag(r *http.Request, p Person) (string, error) {
+	ext := r.URL.Query().Get("ext_json") == "true"
+	v, err := restrictFields(r.Context(), inZone(r.Context(), p), ext)
+	if err != nil {
+		return "", err
+	}
+	var body []byte
+	if ext {
+		body, err = marshalExtJSON(v)
+	} else {
+		body, err = json.Marshal(v)
+	}
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	tag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if config.ETagWeak {
+		tag = "W/" + tag
+	}
+	return tag, nil
+}
+
+// etagMatches reports whether tag is in header, a comma separated list of
+// entity tags or "*". Strong comparison, used for If-Match, requires both
+// tags to be strong; weak comparison, used for If-None-Match, only
+// compares the opaque part (RFC 9110, section 8.8.3.2).
+func etagMatches(header, tag string, strong bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	opaque, weak := strings.CutPrefix(tag, "W/")
+	if strong && weak {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		c, cweak := strings.CutPrefix(candidate, "W/")
+		if strong && cweak {
+			continue
+		}
+		if c == opaque {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchFails reports whether the request has an If-Match precondition
+// that current, the stored person, doesn't satisfy. With weak ETags
+// configured every If-Match fails except "*", since weak tags never
+// compare strongly.
+func ifMatchFails(r *http.Request, current Person) (bool, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return false, nil
+	}
+	tag, err := personETag(r, current)
+	if err != nil {
+		return false, err
+	}
+	return !etagMatches(header, tag, true), nil
+}
+
+// writePerson sends a single person with its validators, Last-Modified and
+// ETag, answering 304 Not Modified when If-None-Match already has it.
+func writePerson(w http.ResponseWriter, r *http.Request, status int, person Person) {
+	setLastModified(w, person)
+	tag, err := personETag(r, person)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	w.Header().Set("ETag", tag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, tag, false) &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, r, status, person)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header, tag string
+		strong      bool
+		want        bool
+	}{
+		{`"a"`, `"a"`, true, true},
+		{`"a"`, `"a"`, false, true},
+		{`"b", "a"`, `"a"`, true, true},
+		{`"b"`, `"a"`, false, false},
+		{`*`, `"a"`, true, true},
+		{` * `, `W/"a"`, false, true},
+		// Weak tags only match under weak comparison.
+		{`W/"a"`, `"a"`, false, true},
+		{`"a"`, `W/"a"`, false, true},
+		{`W/"a"`, `W/"a"`, false, true},
+		{`W/"a"`, `"a"`, true, false},
+		{`"a"`, `W/"a"`, true, false},
+		{`W/"a", "a"`, `"a"`, true, true},
+		{`"ab"`, `"a"`, false, false},
+		{``, `"a"`, false, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, tt.tag, tt.strong); got != tt.want {
+			t.Errorf("etagMatches(%q, %q, strong=%v) = %v, want %v", tt.header, tt.tag, tt.strong, got, tt.want)
+		}
+	}
+}
+
+func TestPersonETag(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	r := httptest.NewRequest("GET", "/people/x", nil)
+	p := Person{Name: "Ann", Age: 30}
+
+	config.ETagWeak = false
+	strong, err := personETag(r, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(strong, `"`) {
+		t.Errorf("strong ETag = %s, want a quoted tag", strong)
+	}
+	if other, _ := personETag(r, Person{Name: "Ann", Age: 31}); other == strong {
+		t.Error("people with different ages have the same ETag")
+	}
+	ext, _ := personETag(httptest.NewRequest("GET", "/people/x?ext_json=true", nil), p)
+	if ext == strong {
+		t.Error("the ext_json representation has the plain JSON ETag")
+	}
+
+	config.ETagWeak = true
+	weak, err := personETag(r, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if weak != "W/"+strong {
+		t.Errorf("weak ETag = %s, want W/%s", weak, strong)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Filter parameters are the where DSL spelled as bracketed query
+// parameters, for clients that can't easily send JSON:
+//
+//	filter[name]=Ann                  {"name": "Ann"}
+//	filter[age][gte]=20               {"age": {"$gte": 20}}
+//	filter[tags][in]=a,b              {"tags": {"$in": ["a", "b"]}}
+//	filter[email][exists]=false       {"email": {"$exists": false}}
+//	filter[or][0][name]=Ann&filter[or][1][age][lt]=18
+//	                                  {"$or": [{"name": "Ann"}, {"age": {"$lt": 18}}]}
+//
+// Conditions at the same level are ANDed; and, or and nor take numbered
+// groups, which may nest. The result is compiled by compileWhere, so the
+// same fields, operators and limits apply.
+
+var filterParamOps = map[string]bool{
+	"eq": true, "ne": true, "gt": true, "gte": true, "lt": true, "lte": true,
+	"in": true, "nin": true, "exists": true,
+}
+
+// filterParams builds a where DSL query from the filter[...] parameters in
+// values. It returns nil if there are none.
+func filterParams(values url.Values) (json.RawMessage, error) {
+	root := map[string]interface{}{}
+	found := false
+	for key, vals := range values {
+		if !strings.HasPrefix(key, "filter[") {
+			continue
+		}
+		found = true
+		path, ok := bracketPath(strings.TrimPrefix(key, "filter"))
+		if !ok {
+			return nil, fmt.Errorf("%s: malformed filter parameter", key)
+		}
+		if len(vals) > 1 {
+			return nil, fmt.Errorf("%s: given more than once", key)
+		}
+		if err := addFilterParam(root, path, vals[0], key); err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	raw, err := json.Marshal(groupsToLists(root))
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// bracketPath splits "[a][b][c]" into a, b and c.
+func bracketPath(s string) ([]string, bool) {
+	var path []string
+	for s != "" {
+		if s[0] != '[' {
+			return nil, false
+		}
+		end := strings.IndexByte(s, ']')
+		if end < 2 {
+			return nil, false
+		}
+		path = append(path, s[1:end])
+		s = s[end+1:]
+	}
+	return path, len(path) > 0
+}
+
+// addFilterParam adds one parameter, at path below node, to the query
+// being built. Groups under a logical operator are kept in maps keyed by
+// their index until groupsToLists orders them.
+func addFilterParam(node map[string]interface{}, path []string, value, key string) error {
+	head := path[0]
+	switch head {
+	case "and", "or", "nor":
+		if len(path) < 3 {
+			return fmt.Errorf("%s: %s takes numbered groups, e.g. filter[%s][0][name]", key, head, head)
+		}
+		i, err := strconv.Atoi(path[1])
+		if err != nil || i < 0 {
+			return fmt.Errorf("%s: group index %q is not a non-negative integer", key, path[1])
+		}
+		groups, _ := node["$"+head].(map[int]map[string]interface{})
+		if groups == nil {
+			groups = map[int]map[string]interface{}{}
+			node["$"+head] = groups
+		}
+		if groups[i] == nil {
+			groups[i] = map[string]interface{}{}
+		}
+		return addFilterParam(groups[i], path[2:], value, key)
+	}
+
+	if !filterFields[head] {
+		return fmt.Errorf("%s: unknown field %q", key, head)
+	}
+	op := "eq"
+	switch len(path) {
+	case 1:
+	case 2:
+		op = path[1]
+		if !filterParamOps[op] {
+			return fmt.Errorf("%s: unsupported operator %q", key, op)
+		}
+	default:
+		return fmt.Errorf("%s: too many brackets after field %q", key, head)
+	}
+
+	var arg interface{}
+	switch op {
+	case "exists":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: must be true or false", key)
+		}
+		arg = b
+	case "in", "nin":
+		list := []interface{}{}
+		for _, item := range splitParams([]string{value}) {
+			v, err := filterParamValue(head, item)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			list = append(list, v)
+		}
+		arg = list
+	default:
+		v, err := filterParamValue(head, value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		arg = v
+	}
+	conds, _ := node[head].(map[string]interface{})
+	if conds == nil {
+		conds = map[string]interface{}{}
+		node[head] = conds
+	}
+	conds["$"+op] = arg
+	return nil
+}
+
+// filterParamValue types a parameter value by field's schema type:
+// numbers for integer fields, strings otherwise. A value for an integer
+// field that isn't a number is rejected.
+func filterParamValue(field, value string) (interface{}, error) {
+	if typ, _ := personFieldType(field); typ == "integer" {
+		// ParseFloat also takes NaN and Inf, which JSON has no literal for.
+		if _, err := strconv.ParseFloat(value, 64); err != nil || !json.Valid([]byte(value)) {
+			return nil, fmt.Errorf("%q is not a number", value)
+		}
+		return json.Number(value), nil
+	}
+	return value, nil
+}
+
+// groupsToLists replaces the index-keyed groups under logical operators
+// with arrays in index order.
+func groupsToLists(node map[string]interface{}) map[string]interface{} {
+	for key, v := range node {
+		groups, ok := v.(map[int]map[string]interface{})
+		if !ok {
+			continue
+		}
+		indexes := make([]int, 0, len(groups))
+		for i := range groups {
+			indexes = append(indexes, i)
+		}
+		sort.Ints(indexes)
+		list := make([]interface{}, len(indexes))
+		for n, i := range indexes {
+			list[n] = groupsToLists(groups[i])
+		}
+		node[key] = list
+	}
+	return node
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFilterParams(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"filter[name]=Ann", `{"name": {"$eq": "Ann"}}`},
+		{"filter[age][gte]=20&filter[age][lt]=30", `{"age": {"$gte": 20, "$lt": 30}}`},
+		{"filter[tags][in]=a,b", `{"tags": {"$in": ["a", "b"]}}`},
+		{"filter[email][exists]=false", `{"email": {"$exists": false}}`},
+		// Numbers only for numeric fields.
+		{"filter[name]=42", `{"name": {"$eq": "42"}}`},
+		{"filter[or][1][age][lt]=18&filter[or][0][name]=Ann",
+			`{"$or": [{"name": {"$eq": "Ann"}}, {"age": {"$lt": 18}}]}`},
+		{"filter[and][0][or][0][name]=a&filter[and][0][or][1][name]=b&filter[and][1][age]=3",
+			`{"$and": [{"$or": [{"name": {"$eq": "a"}}, {"name": {"$eq": "b"}}]}, {"age": {"$eq": 3}}]}`},
+	}
+	for _, tt := range tests {
+		values, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := filterParams(values)
+		if err != nil {
+			t.Errorf("filterParams(%s): %v", tt.query, err)
+			continue
+		}
+		var got, want interface{}
+		if err := json.Unmarshal(raw, &got); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("filterParams(%s) = %s, want %s", tt.query, raw, tt.want)
+		}
+		if _, err := compileWhere(context.Background(), raw); err != nil {
+			t.Errorf("compileWhere rejected filterParams(%s): %v", tt.query, err)
+		}
+	}
+}
+
+func TestFilterParamsNone(t *testing.T) {
+	raw, err := filterParams(url.Values{"name": {"Ann"}, "filters": {"x"}})
+	if raw != nil || err != nil {
+		t.Errorf("filterParams without filter parameters = %s, %v; want nil, nil", raw, err)
+	}
+}
+
+func TestFilterParamsRejects(t *testing.T) {
+	tests := []struct {
+		query, err string
+	}{
+		{"filter[salary]=1", `unknown field "salary"`},
+		{"filter[age][between]=1", `unsupported operator "between"`},
+		{"filter[age][gt][x]=1", "too many brackets"},
+		{"filter[]=1", "malformed filter parameter"},
+		{"filter[name=1", "malformed filter parameter"},
+		{"filter[or][first][name]=Ann", `group index "first"`},
+		{"filter[or][0]=Ann", "takes numbered groups"},
+		{"filter[email][exists]=maybe", "must be true or false"},
+		{"filter[age][gte]=abc", `filter[age][gte]: "abc" is not a number`},
+		{"filter[age][in]=1,two", `filter[age][in]: "two" is not a number`},
+		{"filter[age]=NaN", `filter[age]: "NaN" is not a number`},
+		{"filter[name]=a&filter[name]=b", "given more than once"},
+	}
+	for _, tt := range tests {
+		values, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := filterParams(values); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("filterParams(%s) error = %v, want it to contain %q", tt.query, err, tt.err)
+		}
+	}
+}
+
+func TestGetPeopleRejectsMistypedFilter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	GetPeople(rec, httptest.NewRequest(http.MethodGet, "/people?filter[age][gte]=abc", nil))
+	if body := decodeErrorBody(t, rec); rec.Code != http.StatusBadRequest || body.Error != `filter[age][gte]: "abc" is not a number` {
+		t.Errorf("GET /people?filter[age][gte]=abc: status %d, %+v; want 400", rec.Code, body)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ready reports whether the service can serve traffic. It is set once
+// MongoDB is reachable and the warm-up has run, and is then kept up to
+// date by the health monitor.
+var ready atomic.Bool
+
+// started is set the first time the service becomes ready and then stays
+// set, unlike ready.
+var started atomic.Bool
+
+// startupPaths are served before the service is ready: they don't touch
+// MongoDB.
+var startupPaths = map[string]bool{
+	"/version":      true,
+	"/capabilities": true,
+	"/openapi.json": true,
+	"/openapi.yaml": true,
+}
+
+// startupGateMiddleware answers 503, with Retry-After, for every data
+// endpoint until the service has become ready for the first time. Health
+// probes and startupPaths work throughout. Later readiness changes, from
+// the health monitor, aren't gated: requests then fail on their own if
+// MongoDB is unreachable.
+func startupGateMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !started.Load() && !startupPaths[r.URL.Path] && !strings.HasPrefix(r.URL.Path, "/health/") {
+			writeError(w, http.StatusServiceUnavailable, "the service is starting up")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Liveness answers as long as the process is serving HTTP.
+func Liveness(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
+// Readiness answers 200 while MongoDB is reachable and 503 otherwise,
+// including while shutting down.
+func Readiness(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() || draining.Load() {
+		writeError(w, http.StatusServiceUnavailable, "not ready")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ready\n"))
+}
+
+// pingMongo checks the connection to the primary within timeout.
+func pingMongo(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return client.Ping(ctx, nil)
+}
+
+// The write check upserts a single document, so repeated checks, from any
+// number of instances, never grow the collection. The TTL index on
+// checked_at removes it once checks stop.
+const (
+	healthCollection = "_health"
+	healthDocID      = "write-check"
+	healthDocTTL     = time.Hour
+)
+
+// checkMongo is the health monitor's check: a ping, followed by a write
+// when config.HealthWriteCheck is set, each within timeout.
+func checkMongo(ctx context.Context, timeout time.Duration) error {
+	if err := pingMongo(ctx, timeout); err != nil || !config.HealthWriteCheck {
+		return err
+	}
+	return writeCheck(ctx, timeout)
+}
+
+// writeCheck confirms the primary accepts writes.
+func writeCheck(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	_, err := client.Database(Database).Collection(healthCollection).UpdateOne(ctx,
+		bson.M{"_id": healthDocID},
+		bson.M{"$set": bson.M{"checked_at": now()}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// Two first upserts raced, and the other one inserted it.
+		return nil
+	}
+	return err
+}
+
+// monitorHealth pings MongoDB every interval until ctx is done, updating
+// ready and logging each change between healthy and unhealthy. The driver
+// reconnects on its own; this only makes the state visible to probes
+// between requests.
+func monitorHealth(ctx context.Context, interval time.Duration, ping func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := ping(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		healthy := err == nil
+		if was := ready.Swap(healthy); was != healthy {
+			if healthy {
+				log.Println("MongoDB connection healthy again")
+			} else {
+				log.Println("MongoDB connection unhealthy:", err)
+			}
+		}
+	}
+}
+
+// startUp runs warm when config.Warmup is set, then marks the service
+// ready and started and starts the health monitor. Readiness flips only
+// after the warm-up, so probes keep traffic away until the pool is primed.
+// The monitor starts afterwards so it can't mark the service ready early.
+// If ctx is cancelled first, the service is left unready.
+func startUp(ctx context.Context, warm func(ctx context.Context)) {
+	if config.Warmup {
+		warm(ctx)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	ready.Store(true)
+	started.Store(true)
+	if limiter != nil {
+		limiter.startRamp(time.Now())
+	}
+	log.Println("Ready to serve traffic")
+
+	if config.HealthCheckInterval > 0 {
+		startWorker("health-monitor", func(ctx context.Context) {
+			monitorHealth(ctx, config.HealthCheckInterval, func(ctx context.Context) error {
+				return checkMongo(ctx, config.HealthCheckTimeout)
+			})
+		})
+	}
+}
+
+// warmUp runs a FindOne and a CountDocuments so the connection pool opens
+// connections and the server caches query plans before real traffic
+// arrives. Failures are logged but don't stop startup.
+func warmUp(ctx context.Context, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	collection := client.Database(Database).Collection(Collection)
+	if err := collection.FindOne(ctx, bson.M{}).Err(); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Println("Warm-up FindOne failed:", err)
+	}
+	if _, err := collection.CountDocuments(ctx, bson.M{}); err != nil {
+		log.Println("Warm-up CountDocuments failed:", err)
+	}
+	log.Println("Warm-up finished in", time.Since(start))
+}
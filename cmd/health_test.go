@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMonitorHealth(t *testing.T) {
+	defer ready.Store(ready.Load())
+	ready.Store(true)
+
+	// Each ping hands the test a channel to answer it on. The next ping
+	// only starts once the previous answer has been applied.
+	pings := make(chan chan error)
+	ping := func(ctx context.Context) error {
+		reply := make(chan error)
+		pings <- reply
+		select {
+		case err := <-reply:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		monitorHealth(ctx, time.Millisecond, ping)
+	}()
+
+	(<-pings) <- errors.New("connection refused")
+	reply := <-pings
+	if ready.Load() {
+		t.Error("ready after a failed ping")
+	}
+	reply <- nil
+	<-pings
+	if !ready.Load() {
+		t.Error("not ready after a successful ping")
+	}
+
+	// A ping cut short by shutdown doesn't count as a failure.
+	cancel()
+	<-done
+	if !ready.Load() {
+		t.Error("shutdown during a ping marked the service unhealthy")
+	}
+}
+
+func TestStartupGateMiddleware(t *testing.T) {
+	defer started.Store(started.Load())
+	handler := startupGateMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code
+	}
+
+	started.Store(false)
+	for path, want := range map[string]int{
+		"/people":       http.StatusServiceUnavailable,
+		"/people/top":   http.StatusServiceUnavailable,
+		"/health/live":  http.StatusOK,
+		"/health/ready": http.StatusOK,
+		"/version":      http.StatusOK,
+		"/openapi.json": http.StatusOK,
+	} {
+		if got := serve(path); got != want {
+			t.Errorf("before startup, GET %s: status %d, want %d", path, got, want)
+		}
+	}
+
+	// Once started, a later loss of readiness doesn't close the gate again.
+	defer ready.Store(ready.Load())
+	started.Store(true)
+	ready.Store(false)
+	if got := serve("/people"); got != http.StatusOK {
+		t.Errorf("after startup, GET /people: status %d, want 200", got)
+	}
+}
+
+func TestStartUpWarmsUpFirst(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer ready.Store(ready.Load())
+	defer started.Store(started.Load())
+	config.Warmup = true
+	config.HealthCheckInterval = 0
+
+	ready.Store(false)
+	started.Store(false)
+	warmed := false
+	startUp(context.Background(), func(ctx context.Context) {
+		if ready.Load() || started.Load() {
+			t.Error("ready before the warm-up ran")
+		}
+		warmed = true
+	})
+	if !warmed || !ready.Load() || !started.Load() {
+		t.Errorf("after startup: warmed %v, ready %v, started %v; want all true", warmed, ready.Load(), started.Load())
+	}
+
+	// Shutdown during the warm-up leaves the service unready.
+	ready.Store(false)
+	started.Store(false)
+	ctx, cancel := context.WithCancel(context.Background())
+	startUp(ctx, func(ctx context.Context) { cancel() })
+	if ready.Load() || started.Load() {
+		t.Error("ready after startup was cancelled during the warm-up")
+	}
+}
+
+func TestWriteCheckReusesOneDocument(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.HealthWriteCheck = true
+
+	runMock(t, "repeated checks", func(mt *mtest.T) {
+		ok := mtest.CreateSuccessResponse()
+		updated := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: int32(1)}, bson.E{Key: "nModified", Value: int32(1)})
+		duplicate := mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"})
+		mt.AddMockResponses(ok, updated, ok, updated, ok, duplicate)
+		for i := 0; i < 3; i++ {
+			if err := checkMongo(context.Background(), time.Second); err != nil {
+				mt.Fatalf("check %d: %v", i+1, err)
+			}
+		}
+
+		var writes int
+		for _, e := range mt.GetAllStartedEvents() {
+			switch e.CommandName {
+			case "ping":
+			case "update":
+				writes++
+				update := e.Command.Lookup("updates").Array().Index(0).Value().Document()
+				if update.Lookup("q", "_id").StringValue() != healthDocID || !update.Lookup("upsert").Boolean() {
+					mt.Errorf("write check update %s, want an upsert of %s", update, healthDocID)
+				}
+			default:
+				mt.Errorf("write check ran %s, which could add documents", e.CommandName)
+			}
+		}
+		if writes != 3 {
+			mt.Errorf("%d writes for 3 checks", writes)
+		}
+	})
+}
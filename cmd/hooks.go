@@ -0,0 +1,36 @@
+package main
+
+// BeforeInsertHook runs on every person before it is inserted, whether by
+// POST /people or by a PUT that creates the person, and before the person
+// is validated. It may modify the person, or reject it by returning a
+// HookRejection. Any other error fails the request with a 500.
+type BeforeInsertHook func(*Person) error
+
+// HookRejection is returned by a hook to refuse a document; Reason is
+// sent to the client with a 422, like other validation failures.
+type HookRejection struct {
+	Reason string
+}
+
+func (e HookRejection) Error() string {
+	return e.Reason
+}
+
+// beforeInsert is the hook in use. By default it does nothing.
+var beforeInsert BeforeInsertHook = func(*Person) error { return nil }
+
+// RegisterBeforeInsert sets the hook run before inserts. It must be called
+// during startup, before the server starts handling requests.
+func RegisterBeforeInsert(hook BeforeInsertHook) {
+	beforeInsert = hook
+}
+
+// prepareInsert runs the insert hook on a person read from a request, then
+// checkPerson, so what the hook adds or changes is checked like the
+// client's own fields.
+func prepareInsert(person *Person) error {
+	if err := beforeInsert(person); err != nil {
+		return err
+	}
+	return checkPerson(person)
+}
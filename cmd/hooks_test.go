@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestBeforeInsertHook(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(h BeforeInsertHook) { beforeInsert = h }(beforeInsert)
+	config.NaturalKey = "email"
+	ns := Database + "." + Collection
+
+	// The hook names anonymous people, so a body without a name, which
+	// would fail validation, is inserted.
+	RegisterBeforeInsert(func(p *Person) error {
+		if p.Name == "" {
+			p.Name = "Anonymous"
+		}
+		return nil
+	})
+	upserted := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: int32(1)},
+		bson.E{Key: "upserted", Value: bson.A{bson.D{{Key: "index", Value: int32(0)}, {Key: "_id", Value: primitive.NewObjectID()}}}})
+	tests := []struct {
+		name, method, path string
+		responses          []bson.D
+		// inserted finds the inserted document in the write command.
+		inserted func(cmd bson.Raw) bson.RawValue
+	}{
+		{"create", "POST", "/people", []bson.D{mtest.CreateSuccessResponse()},
+			func(cmd bson.Raw) bson.RawValue { return cmd.Lookup("documents").Array().Index(0).Value() }},
+		{"if_not_exists", "POST", "/people?if_not_exists=email", []bson.D{upserted},
+			func(cmd bson.Raw) bson.RawValue {
+				return cmd.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$setOnInsert")
+			}},
+		{"upsert", "PUT", "/people/ann@example.com",
+			[]bson.D{mtest.CreateCursorResponse(0, ns, mtest.FirstBatch), mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse()},
+			func(cmd bson.Raw) bson.RawValue { return cmd.Lookup("documents").Array().Index(0).Value() }},
+	}
+	for _, tt := range tests {
+		runMock(t, tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(tt.responses...)
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"age":30,"email":"ann@example.com"}`))
+			rec := httptest.NewRecorder()
+			if tt.method == "PUT" {
+				UpdatePerson(rec, mux.SetURLVars(r, map[string]string{"id": "ann@example.com"}))
+			} else {
+				CreatePerson(rec, r)
+			}
+			if rec.Code != http.StatusCreated {
+				mt.Fatalf("status %d, want 201: %s", rec.Code, rec.Body)
+			}
+			for _, e := range mt.GetAllStartedEvents() {
+				if e.CommandName != "insert" && e.CommandName != "update" {
+					continue
+				}
+				if name := tt.inserted(e.Command).Document().Lookup("name"); name.StringValue() != "Anonymous" {
+					mt.Errorf("inserted name %s, want the hook's Anonymous", name)
+				}
+				return
+			}
+			mt.Error("nothing was inserted")
+		})
+	}
+}
+
+func TestBeforeInsertHookRejects(t *testing.T) {
+	defer func(h BeforeInsertHook) { beforeInsert = h }(beforeInsert)
+
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{HookRejection{Reason: "no minors"}, http.StatusUnprocessableEntity},
+		{fmt.Errorf("policy: %w", HookRejection{Reason: "no minors"}), http.StatusUnprocessableEntity},
+		{fmt.Errorf("policy service unavailable"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		RegisterBeforeInsert(func(*Person) error { return tt.err })
+		rec := httptest.NewRecorder()
+		CreatePerson(rec, httptest.NewRequest("POST", "/people", strings.NewReader(`{"name":"Ann","age":12}`)))
+		if rec.Code != tt.want {
+			t.Errorf("hook error %q: status %d, want %d", tt.err, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusUnprocessableEntity && decodeErrorBody(t, rec).Error != "no minors" {
+			t.Errorf("hook rejection answered %s, want its reason", rec.Body)
+		}
+	}
+}
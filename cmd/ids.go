@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Policies for a PUT body whose id differs from the person being replaced.
+const (
+	// idMismatchReject answers 409, to catch clients writing to the wrong
+	// person. This is the default.
+	idMismatchReject = "reject"
+	// idMismatchIgnore drops the body id; the path decides.
+	idMismatchIgnore = "ignore"
+)
+
+var errIDMismatch = errors.New("id in the body does not match the person being replaced")
+
+// personFilter returns the filter selecting the person named by the {id}
+// path segment, leaving out soft-deleted people.
+//
+// A segment that parses as an ObjectID (24 hex characters) is always looked
+// up by _id. Anything else is matched against the natural key field
+// (config.NaturalKey, email by default). A natural key value that happens
+// to be 24 hex characters can therefore only be reached by its _id. With
+// no natural key configured, or one the caller can't see, segments that
+// aren't ObjectIDs are rejected, as are segments without an @ when the
+// natural key is email. name is the parameter the segment came from, for
+// the error message.
+func personFilter(ctx context.Context, name, segment string) (bson.M, error) {
+	filter, err := personKeyFilter(ctx, name, segment)
+	if err != nil {
+		return nil, err
+	}
+	return liveFilter(filter), nil
+}
+
+// personKeyFilter is personFilter without the soft delete condition.
+func personKeyFilter(ctx context.Context, name, segment string) (bson.M, error) {
+	if config.NaturalKey == "" || !fieldVisible(ctx, config.NaturalKey) {
+		objectID, err := parseObjectID(name, segment)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"_id": objectID}, nil
+	}
+	if objectID, err := primitive.ObjectIDFromHex(segment); err == nil {
+		return bson.M{"_id": objectID}, nil
+	}
+	if config.NaturalKey == "email" {
+		// Without an @ the segment can't be an email, and is more likely a
+		// mistyped ObjectID than an address no one will have.
+		if !strings.Contains(segment, "@") {
+			return nil, fmt.Errorf("%s must be a 24-character hex string or an email address", name)
+		}
+		if config.NormalizeEmail {
+			segment = normalizeEmail(segment)
+		}
+	}
+	return bson.M{config.NaturalKey: segment}, nil
+}
+
+// parseObjectID parses an id taken from parameter name. Every id a client
+// sends goes through here, so they are all rejected with the same message;
+// the driver's own error doesn't say what an ObjectID looks like.
+func parseObjectID(name, s string) (primitive.ObjectID, error) {
+	objectID, err := primitive.ObjectIDFromHex(s)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("%s must be a 24-character hex string", name)
+	}
+	return objectID, nil
+}
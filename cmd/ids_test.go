@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPersonKeyFilter(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	id := primitive.NewObjectID()
+
+	config.NaturalKey, config.NormalizeEmail = "email", true
+	tests := []struct {
+		segment string
+		want    bson.M
+	}{
+		{id.Hex(), bson.M{"_id": id}},
+		{"Ann@Example.com", bson.M{"email": "ann@example.com"}},
+	}
+	for _, tt := range tests {
+		got, err := personKeyFilter(context.Background(), "id", tt.segment)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("personKeyFilter(%q) = %v, %v; want %v", tt.segment, got, err, tt.want)
+		}
+	}
+	// Neither an ObjectID nor an email: a short or long hex string, or a
+	// plain word.
+	for _, segment := range []string{id.Hex()[:23], id.Hex() + "0", "ann"} {
+		_, err := personKeyFilter(context.Background(), "id", segment)
+		if want := "id must be a 24-character hex string or an email address"; err == nil || err.Error() != want {
+			t.Errorf("personKeyFilter(%q) error = %v, want %q", segment, err, want)
+		}
+	}
+
+	config.NaturalKey = "name"
+	if got, err := personKeyFilter(context.Background(), "id", "abc"); err != nil || !reflect.DeepEqual(got, bson.M{"name": "abc"}) {
+		t.Errorf("personKeyFilter(abc) by name = %v, %v", got, err)
+	}
+
+	config.NaturalKey = ""
+	if _, err := personKeyFilter(context.Background(), "against", "ann@example.com"); err == nil || err.Error() != "against must be a 24-character hex string" {
+		t.Errorf("personKeyFilter without a natural key: error = %v", err)
+	}
+}
+
+func TestParseObjectID(t *testing.T) {
+	id := primitive.NewObjectID()
+	if got, err := parseObjectID("id", id.Hex()); err != nil || got != id {
+		t.Errorf("parseObjectID(%s) = %v, %v", id.Hex(), got, err)
+	}
+	for _, s := range []string{"", "xyz", id.Hex()[:22], id.Hex() + "00"} {
+		if _, err := parseObjectID("ids[2]", s); err == nil || err.Error() != "ids[2] must be a 24-character hex string" {
+			t.Errorf("parseObjectID(%q) error = %v", s, err)
+		}
+	}
+}
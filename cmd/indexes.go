@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexSpec is an index to create at startup. MinFCV is the lowest
+// feature compatibility version that supports it, empty for any.
+type indexSpec struct {
+	Model  mongo.IndexModel
+	MinFCV string
+}
+
+var indexSpecs = []indexSpec{
+	{
+		// Email is optional, so only documents that have one take part in
+		// the uniqueness check.
+		Model: mongo.IndexModel{
+			Keys: bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().
+				SetName("email_unique").
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"email": bson.M{"$type": "string"}}),
+		},
+	},
+	{
+		// GET /people can filter on any combination of the filter fields,
+		// too many to give each its own index. A wildcard index over them
+		// serves a condition on any one. Wildcard indexes need FCV 4.2.
+		Model: mongo.IndexModel{
+			Keys: bson.D{{Key: "$**", Value: 1}},
+			Options: options.Index().
+				SetName("filter_fields_wildcard").
+				SetWildcardProjection(bson.M{"name": 1, "age": 1, "address": 1, "email": 1, "tags": 1}),
+		},
+		MinFCV: "4.2",
+	},
+}
+
+// featureCompatibilityVersion asks the server for its FCV. It is a
+// variable so the check can be stubbed.
+var featureCompatibilityVersion = func(ctx context.Context) (string, error) {
+	var res struct {
+		FCV struct {
+			Version string `bson:"version"`
+		} `bson:"featureCompatibilityVersion"`
+	}
+	cmd := bson.D{{Key: "getParameter", Value: 1}, {Key: "featureCompatibilityVersion", Value: 1}}
+	if err := client.Database("admin").RunCommand(ctx, cmd).Decode(&res); err != nil {
+		return "", err
+	}
+	return res.FCV.Version, nil
+}
+
+// ensureIndexes creates the indexes the handlers rely on. Creating an index
+// that already exists with the same options is a no-op.
+//
+// Indexes the server's FCV doesn't support are skipped with a warning, or
+// fail startup when config.IndexStrict is set. If the FCV can't be read
+// (some hosted tiers don't allow getParameter), every index is attempted.
+func ensureIndexes(ctx context.Context) error {
+	models, err := indexModels(ctx)
+	if err != nil {
+		return err
+	}
+	if config.HealthWriteCheck {
+		if _, err := client.Database(Database).Collection(healthCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "checked_at", Value: 1}},
+			Options: options.Index().SetName("checked_at_ttl").SetExpireAfterSeconds(int32(healthDocTTL.Seconds())),
+		}); err != nil {
+			return err
+		}
+	}
+	if len(models) == 0 {
+		return nil
+	}
+
+	collection := client.Database(Database).Collection(Collection)
+	if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+		return err
+	}
+	log.Println("Indexes ready")
+	return nil
+}
+
+// indexModels returns the models in indexSpecs that the server's FCV
+// supports, see ensureIndexes.
+func indexModels(ctx context.Context) ([]mongo.IndexModel, error) {
+	fcv, err := featureCompatibilityVersion(ctx)
+	if err != nil {
+		log.Println("Warning: could not read featureCompatibilityVersion, creating all indexes:", err)
+	}
+
+	var models []mongo.IndexModel
+	for _, spec := range indexSpecs {
+		if fcv != "" && spec.MinFCV != "" && compareVersions(fcv, spec.MinFCV) < 0 {
+			name := *spec.Model.Options.Name
+			if config.IndexStrict {
+				return nil, fmt.Errorf("index %s needs featureCompatibilityVersion %s, server has %s", name, spec.MinFCV, fcv)
+			}
+			log.Printf("Warning: skipping index %s, it needs featureCompatibilityVersion %s and the server has %s", name, spec.MinFCV, fcv)
+			continue
+		}
+		models = append(models, spec.Model)
+	}
+	return models, nil
+}
+
+// compareVersions compares dotted numeric versions such as "4.4" and
+// "7.0", returning -1, 0 or 1. Missing parts count as 0.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
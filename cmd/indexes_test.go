@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// stubFCV makes featureCompatibilityVersion answer fcv, or fail if err is
+// set, until the test ends.
+func stubFCV(t *testing.T, fcv string, err error) {
+	saved := featureCompatibilityVersion
+	t.Cleanup(func() { featureCompatibilityVersion = saved })
+	featureCompatibilityVersion = func(context.Context) (string, error) { return fcv, err }
+}
+
+func indexNames(t *testing.T) []string {
+	t.Helper()
+	models, err := indexModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range models {
+		names = append(names, *m.Options.Name)
+	}
+	return names
+}
+
+func TestIndexModels(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.IndexStrict = false
+
+	tests := []struct {
+		fcv  string
+		err  error
+		want []string
+	}{
+		{"7.0", nil, []string{"email_unique", "filter_fields_wildcard"}},
+		{"4.2", nil, []string{"email_unique", "filter_fields_wildcard"}},
+		{"4.0", nil, []string{"email_unique"}},
+		// An unreadable FCV attempts everything.
+		{"", errors.New("not authorized on admin"), []string{"email_unique", "filter_fields_wildcard"}},
+	}
+	for _, tt := range tests {
+		stubFCV(t, tt.fcv, tt.err)
+		if got := indexNames(t); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("indexes with FCV %q = %v, want %v", tt.fcv, got, tt.want)
+		}
+	}
+}
+
+func TestIndexModelsStrict(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.IndexStrict = true
+
+	stubFCV(t, "4.0", nil)
+	if _, err := indexModels(context.Background()); err == nil {
+		t.Error("indexModels skipped an index despite INDEX_STRICT")
+	}
+	stubFCV(t, "4.2", nil)
+	if _, err := indexModels(context.Background()); err != nil {
+		t.Errorf("indexModels with FCV 4.2: %v", err)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"4.2", "4.2", 0},
+		{"4.0", "4.2", -1},
+		{"10.0", "4.2", 1},
+		{"4.2", "4.2.0", 0},
+		{"4.2.1", "4.2", 1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -3,10 +3,15 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -15,13 +20,36 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
 )
 
 type Person struct {
 	ID      primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	Name    string             `json:"name"`
 	Age     int                `json:"age"`
-	Address string             `json:"address"`
+	Address string             `json:"address" bson:"address,omitempty"`
+	Email   string             `json:"email,omitempty" bson:"email,omitempty"`
+	Tags    []string           `json:"tags,omitempty" bson:"tags,omitempty"`
+
+	// Set by the server; values sent by clients are ignored.
+	CreatedAt *time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+
+	// CreatedBy and UpdatedBy are the ids of the callers who wrote the
+	// person, see actor. Also set by the server.
+	CreatedBy string `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	UpdatedBy string `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+
+	// DeletedAt is set when the person is soft-deleted, see
+	// config.SoftDelete.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+}
+
+// now returns the current time as stored by MongoDB, which keeps
+// millisecond precision.
+func now() *time.Time {
+	t := time.Now().UTC().Truncate(time.Millisecond)
+	return &t
 }
 
 const (
@@ -31,73 +59,196 @@ const (
 
 var client *mongo.Client
 
-func init() {
+// setup loads the configuration, connects to MongoDB and creates the
+// indexes. It runs from main rather than init so tests can build the
+// package without a .env file or a server.
+func setup() {
 	if err := godotenv.Load(); err != nil {
 		log.Fatal("Error loading .env file")
 	}
+	var err error
+	config, err = loadConfig()
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
 	uri := os.Getenv("URI")
 	if uri == "" {
 		log.Fatal("MONGODB_URI environment variable is not set")
 	}
-	var err error
-	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
-	fmt.Println(os.Getenv("URI"))
-	opts := options.Client().ApplyURI(os.Getenv("URI")).SetServerAPIOptions(serverAPI)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	opts, err := clientOptions(uri)
+	if err != nil {
+		log.Fatal("Invalid URI: ", err)
+	}
 	client, err = mongo.Connect(context.TODO(), opts)
 	if err != nil {
 		log.Fatal("Error connecting to MongoDB:", err)
 	}
 
-	err = client.Ping(ctx, nil)
+	// Connect doesn't wait for a server; the ping does, for up to the
+	// server selection timeout.
+	err = client.Ping(context.Background(), nil)
 	if err != nil {
 		log.Fatal("Error pinging MongoDB:", err)
 	}
 
 	log.Println("Connected to MongoDB")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := ensureIndexes(ctx); err != nil {
+		log.Fatal("Error creating indexes:", err)
+	}
+}
+
+// clientOptions builds the MongoDB client options for uri and config.
+// ApplyURI records parse errors instead of returning them, and Connect
+// would report them mixed in with connection problems, so they are
+// returned here. Connection string options such as replicaSet or
+// authSource are checked too.
+func clientOptions(uri string) (*options.ClientOptions, error) {
+	serverAPI := options.ServerAPI(options.ServerAPIVersion1).SetStrict(config.ServerAPIStrict)
+	opts := options.Client().ApplyURI(uri).SetServerAPIOptions(serverAPI)
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	opts.SetServerSelectionTimeout(config.ServerSelectionTimeout).SetConnectTimeout(config.ConnectTimeout)
+	opts.SetMaxPoolSize(uint64(config.PoolMaxSize)).SetMaxConnecting(uint64(config.PoolMaxConnecting)).
+		SetPoolMonitor(pool.monitor())
+	return opts, nil
 }
 
 func main() {
-	defer func() {
-		if err := client.Disconnect(context.Background()); err != nil {
-			log.Fatal("Error disconnecting from MongoDB:", err)
-		}
-	}()
+	setup()
+	if config.RateLimit > 0 {
+		limiter = newRateLimiter(config.RateLimit, config.RateLimitBurst, config.RateLimitSlowStart, time.Now())
+	}
+
+	startWorker("startup", func(ctx context.Context) {
+		startUp(ctx, func(ctx context.Context) { warmUp(ctx, config.WarmupTimeout) })
+	})
 
 	router := mux.NewRouter()
+	router.Use(requestIDMiddleware)
+	router.Use(drainMiddleware)
+	if config.StartupGate {
+		router.Use(startupGateMiddleware)
+	}
+	if limiter != nil {
+		router.Use(rateLimitMiddleware)
+	}
+	if config.PoolExhausted == poolFailFast {
+		router.Use(poolGateMiddleware)
+	}
+	router.Use(authMiddleware)
+	router.Use(gzipMiddleware)
+	router.Use(noStoreMiddleware)
+	router.Use(maxTimeMiddleware)
+	router.Use(timezoneMiddleware)
+	router.Use(strictParamsMiddleware)
+	if config.ReadOnly {
+		log.Println("Read-only mode: writes are disabled")
+		router.Use(readOnlyMiddleware)
+	}
+	if config.BodyLog {
+		router.Use(bodyLogMiddleware)
+	}
+
+	if err := registerRoutes(router, []route{
+		{"GET", "/health/live", Liveness},
+		{"GET", "/health/ready", Readiness},
+		{"GET", "/version", cacheable(GetVersion)},
+		{"GET", "/capabilities", cacheable(GetCapabilities)},
+		{"GET", "/openapi.json", cacheable(GetOpenAPIJSON)},
+		{"GET", "/openapi.yaml", cacheable(GetOpenAPIYAML)},
+		{"GET", "/admin/stats", adminOnly(GetAdminStats)},
+		{"GET", "/admin/config", adminOnly(GetAdminConfig)},
+		{"POST", "/admin/recompute", adminOnly(RecomputeField)},
+		{"GET", "/people", GetPeople},
+		{"GET", "/people/schema", cacheable(GetPersonSchema)},
+		{"GET", "/people/export.csv", ExportPeopleCSV},
+		{"GET", "/people/stream", StreamPeople},
+		{"GET", "/people/percentiles", GetPercentiles},
+		{"GET", "/people/top", GetTopPeople},
+		{"GET", "/people/group-by", GroupPeople},
+		{"GET", "/people/timeline", GetTimeline},
+		{"GET", "/people/trash", adminOnly(GetTrash)},
+		{"GET", "/people/at/{n}", GetPersonAt},
+		{"GET", "/people/{id}", GetPerson},
+		{"GET", "/people/{id}/diff", DiffPeople},
+		{"POST", "/people", CreatePerson},
+		{"POST", "/people/search", SearchPeople},
+		{"POST", "/people/bulk-update", BulkUpdatePeople},
+		{"POST", "/people/bulk-update/preview", PreviewBulkUpdate},
+		{"POST", "/people/tag", TagPeople},
+		{"POST", "/people/untag", UntagPeople},
+		{"POST", "/people/{id}/restore", adminOnly(RestorePerson)},
+		{"PUT", "/people/{id}", UpdatePerson},
+		{"PATCH", "/people/{id}", PatchPerson},
+		{"DELETE", "/people/{id}", DeletePerson},
+	}); err != nil {
+		log.Fatal("Invalid routes: ", err)
+	}
 
-	router.HandleFunc("/people", GetPeople).Methods("GET")
-	router.HandleFunc("/people/{id}", GetPerson).Methods("GET")
-	router.HandleFunc("/people", CreatePerson).Methods("POST")
-	router.HandleFunc("/people/{id}", UpdatePerson).Methods("PUT")
-	router.HandleFunc("/people/{id}", DeletePerson).Methods("DELETE")
+	srv := &http.Server{Addr: ":8080", Handler: router}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Error starting server:", err)
+		}
+	}()
 	log.Println("Server Started")
-	log.Fatal(http.ListenAndServe(":8080", router))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	shutdownServer(srv, config.ShutdownTimeout, config.StreamDrainTimeout, config.ShutdownTimeout)
+
+	if err := client.Disconnect(context.Background()); err != nil {
+		log.Fatal("Error disconnecting from MongoDB:", err)
+	}
+	log.Println("Server Stopped")
 }
 
 func GetPeople(w http.ResponseWriter, r *http.Request) {
 	log.Println("Handling GET request for /people")
-	collection := client.Database(Database).Collection(Collection)
-	cur, err := collection.Find(context.Background(), bson.D{})
+	q, err := parsePeopleQuery(r.URL.Query())
 	if err != nil {
-		handleError(w, err)
+		handleClientError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	defer cur.Close(context.Background())
 
-	var people []Person
-	for cur.Next(context.Background()) {
-		var person Person
-		if err := cur.Decode(&person); err != nil {
-			handleError(w, err)
-			return
-		}
-		people = append(people, person)
+	page, err := listPeople(r.Context(), q)
+	if err != nil {
+		handleListError(w, err)
+		return
+	}
+
+	setPageHeaders(w, page)
+	writeJSON(w, r, http.StatusOK, page.Items)
+}
+
+// SearchPeople is GetPeople with the query in a JSON body, for filters too
+// large for a URL. It is a read and has no side effects, and answers in the
+// same shape as GetPeople: the people as an array, with the total and the
+// next cursor in headers.
+func SearchPeople(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling POST request for /people/search")
+	var q PeopleQuery
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&q); err != nil {
+		handleClientError(w, http.StatusBadRequest, "invalid search body: "+err.Error())
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(people)
+	page, err := listPeople(r.Context(), q)
+	if err != nil {
+		handleListError(w, err)
+		return
+	}
+
+	setPageHeaders(w, page)
+	writeJSON(w, r, http.StatusOK, page.Items)
 }
 
 func GetPerson(w http.ResponseWriter, r *http.Request) {
@@ -105,37 +256,104 @@ func GetPerson(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id := params["id"]
 
-	objectID, err := primitive.ObjectIDFromHex(id)
+	filter, err := personFilter(r.Context(), "id", id)
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	person, err := findPerson(r.Context(), filter)
 	if err != nil {
 		handleError(w, err)
 		return
 	}
 
-	collection := client.Database(Database).Collection(Collection)
-	result := collection.FindOne(context.Background(), bson.M{"_id": objectID})
+	writePerson(w, r, http.StatusOK, person)
+}
+
+// GetPersonAt returns the n-th person (from 0) in the default sort order.
+// It skips n documents, so it is only meant for small collections and
+// tooling, not for paging through large ones.
+func GetPersonAt(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling GET request for /people/at/n")
+	raw := mux.Vars(r)["n"]
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n < 0 || strings.TrimLeft(raw, "0123456789") != "" {
+		handleClientError(w, http.StatusBadRequest, "n must be a non-negative integer")
+		return
+	}
+
+	sort, _ := PeopleQuery{}.sortSpec(r.Context())
+	opts := options.FindOne().SetSort(sort).SetSkip(n)
+	if projection := projectionFor(r.Context()); projection != nil {
+		opts.SetProjection(projection)
+	}
 
 	var person Person
-	err = result.Decode(&person)
+	collection := client.Database(Database).Collection(Collection)
+	err = collection.FindOne(r.Context(), liveFilter(bson.M{}), opts).Decode(&person)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		handleClientError(w, http.StatusNotFound, fmt.Sprintf("no person at position %d", n))
+		return
+	}
 	if err != nil {
 		handleError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(person)
+	writePerson(w, r, http.StatusOK, person)
 }
 
+// findPerson fetches the person matching filter, limited to the fields
+// the caller may see.
+func findPerson(ctx context.Context, filter bson.M) (Person, error) {
+	collection := client.Database(Database).Collection(Collection)
+	opts := options.FindOne()
+	if projection := projectionFor(ctx); projection != nil {
+		opts.SetProjection(projection)
+	}
+	var person Person
+	err := collection.FindOne(ctx, filter, opts).Decode(&person)
+	return person, err
+}
+
+// CreatePerson inserts a new person. With if_not_exists set to the natural
+// key field, e.g. ?if_not_exists=email, it is an idempotent create that
+// answers 409 instead of inserting a second person with the same key.
 func CreatePerson(w http.ResponseWriter, r *http.Request) {
 	log.Println("Handling POST request CreatePErson")
+	ifNotExists := r.URL.Query().Get("if_not_exists")
+	if ifNotExists != "" && config.NaturalKey == "" {
+		handleClientError(w, http.StatusBadRequest, "if_not_exists needs a natural key, and NATURAL_KEY is not set")
+		return
+	}
+	if ifNotExists != "" && ifNotExists != config.NaturalKey {
+		handleClientError(w, http.StatusBadRequest, fmt.Sprintf("if_not_exists must be the natural key field %q", config.NaturalKey))
+		return
+	}
 	var person Person
-	err := json.NewDecoder(r.Body).Decode(&person)
+	if !readPerson(w, r, &person) {
+		return
+	}
+	if err := prepareInsert(&person); err != nil {
+		handleError(w, err)
+		return
+	}
+	person.UpdatedAt, person.UpdatedBy = now(), actor(r.Context())
+	person.CreatedAt, person.CreatedBy = person.UpdatedAt, person.UpdatedBy
+
+	doc, err := storedPerson(person)
 	if err != nil {
 		handleError(w, err)
 		return
 	}
+	if ifNotExists != "" {
+		createIfNotExists(w, r, person, doc)
+		return
+	}
 
 	collection := client.Database(Database).Collection(Collection)
-	result, err := collection.InsertOne(context.Background(), person)
+	result, err := collection.InsertOne(context.Background(), doc)
 	if err != nil {
 		handleError(w, err)
 		return
@@ -143,48 +361,234 @@ func CreatePerson(w http.ResponseWriter, r *http.Request) {
 
 	person.ID = result.InsertedID.(primitive.ObjectID)
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(person)
+	writeJSON(w, r, http.StatusCreated, person)
 }
 
 func UpdatePerson(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id := params["id"]
 
-	var person Person
-	err := json.NewDecoder(r.Body).Decode(&person)
+	filter, err := personFilter(r.Context(), "id", id)
 	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	mode, ok := parseOnConflict(r)
+	if !ok {
+		handleClientError(w, http.StatusBadRequest, "on_conflict must be update or reject")
+		return
+	}
+
+	var person Person
+	if !readPerson(w, r, &person) {
+		return
+	}
+	person.UpdatedAt, person.UpdatedBy = now(), actor(r.Context())
+	if config.IDMismatch == idMismatchIgnore {
+		// Dropped from the person itself, not just from the check, or the
+		// upsert would $set the body's _id.
+		person.ID = primitive.NilObjectID
+	}
+	bodyID := person.ID
+	_, byID := filter["_id"]
+	key, _ := filter[config.NaturalKey].(string)
+
+	// A body id is checked against the stored person, so under the reject
+	// policy a natural key PUT carrying one only updates: it can't name
+	// the person it would insert. An upsert checks the body itself, once it
+	// knows whether the insert hook runs first.
+	writeFilter, conditional := unmodifiedSinceFilter(r, filter)
+	ifMatch := r.Header.Get("If-Match") != ""
+	if !byID && !conditional && !ifMatch && bodyID.IsZero() {
+		upsertPerson(w, r, filter, key, person, mode)
+		return
+	}
+
+	if err := checkPerson(&person); err != nil {
 		handleError(w, err)
 		return
 	}
+	if !byID && !setNaturalKey(&person, key) {
+		handleClientError(w, http.StatusConflict, fmt.Sprintf("%s in the body does not match the path", config.NaturalKey))
+		return
+	}
 
+	// PUT replaces the whole document, so the stored one is read first to
+	// carry its server managed fields over. Both happen in a transaction
+	// so a concurrent write can't slip in between.
 	collection := client.Database(Database).Collection(Collection)
-	_, err = collection.UpdateOne(context.Background(), bson.M{"_id": id}, bson.M{"$set": person})
+	var changed bool
+	err = withTransaction(r.Context(), func(sc mongo.SessionContext) error {
+		var existing Person
+		if err := collection.FindOne(sc, writeFilter).Decode(&existing); err != nil {
+			return err
+		}
+		if failed, err := ifMatchFails(r, existing); err != nil || failed {
+			if failed {
+				return errETagMismatch
+			}
+			return err
+		}
+		if !bodyID.IsZero() && bodyID != existing.ID {
+			return errIDMismatch
+		}
+		replacement := person
+		preserveManagedFields(&replacement, existing)
+		doc, err := storedPerson(replacement)
+		if err != nil {
+			return err
+		}
+		result, err := collection.ReplaceOne(sc, bson.M{"_id": existing.ID}, doc)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			// Deleted after we read it.
+			return mongo.ErrNoDocuments
+		}
+		person, changed = replacement, personChanged(existing, replacement)
+		return nil
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		handleNoMatch(r.Context(), w, filter, conditional)
+		return
+	}
+	if errors.Is(err, errETagMismatch) {
+		handleClientError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+	if errors.Is(err, errIDMismatch) {
+		handleClientError(w, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
 		handleError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(person)
+	setUpdateCounts(w, 1, changed)
+	writePerson(w, r, http.StatusOK, person)
+}
+
+// preserveManagedFields copies the fields only the server sets from the
+// stored document into a client supplied replacement.
+func preserveManagedFields(replacement *Person, existing Person) {
+	replacement.ID = existing.ID
+	replacement.CreatedAt = existing.CreatedAt
+	replacement.CreatedBy = existing.CreatedBy
 }
 
 func DeletePerson(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id := params["id"]
 
+	key, err := personKeyFilter(r.Context(), "id", id)
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	collection := client.Database(Database).Collection(Collection)
-	_, err := collection.DeleteOne(context.Background(), bson.M{"_id": id})
+	writeFilter, conditional := unmodifiedSinceFilter(r, liveFilter(key))
+	var deleted int64
+	if r.Header.Get("If-Match") == "" {
+		deleted, err = removePerson(context.Background(), writeFilter)
+	} else {
+		// The ETag is computed from the stored document, so it is read and
+		// deleted in one transaction.
+		err = withTransaction(r.Context(), func(sc mongo.SessionContext) error {
+			deleted = 0
+			var existing Person
+			if err := collection.FindOne(sc, writeFilter).Decode(&existing); err != nil {
+				if errors.Is(err, mongo.ErrNoDocuments) {
+					return nil
+				}
+				return err
+			}
+			if failed, err := ifMatchFails(r, existing); err != nil || failed {
+				if failed {
+					return errETagMismatch
+				}
+				return err
+			}
+			var err error
+			deleted, err = removePerson(sc, bson.M{"_id": existing.ID})
+			return err
+		})
+	}
+	if errors.Is(err, errETagMismatch) {
+		handleClientError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
 	if err != nil {
 		handleError(w, err)
 		return
 	}
+	if deleted == 0 {
+		handleDeleteNoMatch(r.Context(), w, key, conditional)
+		return
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func handleError(w http.ResponseWriter, err error) {
+	var invalid invalidPerson
+	if errors.As(err, &invalid) {
+		handleClientError(w, invalid.status, invalid.msg)
+		return
+	}
+	var rejection HookRejection
+	if errors.As(err, &rejection) {
+		handleClientError(w, http.StatusUnprocessableEntity, rejection.Reason)
+		return
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		handleClientError(w, http.StatusNotFound, "person not found")
+		return
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		handleClientError(w, http.StatusConflict, "a person with that email already exists")
+		return
+	}
+	if op, ok := apiStrictRejection(err); ok {
+		if op == "" {
+			op = "an operation"
+		}
+		log.Println("API strict mode rejected", op+":", err)
+		writeError(w, http.StatusInternalServerError, "the server's Stable API strict mode does not allow "+op+
+			"; disable SERVER_API_STRICT or avoid this feature")
+		return
+	}
+	if isMaxTimeExpired(err) {
+		log.Println("Query timed out:", err)
+		writeError(w, http.StatusGatewayTimeout, "query exceeded its time limit")
+		return
+	}
+	if errors.As(err, new(topology.WaitQueueTimeoutError)) {
+		log.Println("No connection available:", err)
+		writeError(w, http.StatusServiceUnavailable, "no database connection available")
+		return
+	}
+	if mongo.IsNetworkError(err) {
+		log.Println("Database unavailable:", err)
+		writeError(w, http.StatusServiceUnavailable, "database unavailable")
+		return
+	}
 	log.Println("Error:", err)
-	http.Error(w, err.Error(), http.StatusInternalServerError)
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func handleClientError(w http.ResponseWriter, status int, msg string) {
+	log.Println("Client error:", msg)
+	writeError(w, status, msg)
+}
+
+// abortStream ends a streamed response that failed after its headers were
+// sent. The connection is cut instead of the body being finished, so the
+// client sees a broken transfer rather than an export that looks complete.
+func abortStream(what string, err error) {
+	log.Println("Error streaming "+what+":", err)
+	panic(http.ErrAbortHandler)
 }
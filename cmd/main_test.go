@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestMain gives the tests the configuration loadConfig reads from the
+// environment, defaults for everything unset. setup isn't run, so no test
+// can reach MongoDB; handlers that need it run against a mocked deployment,
+// see runMock.
+func TestMain(m *testing.M) {
+	var err error
+	if config, err = loadConfig(); err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestClientOptionsRejectsMalformedURI(t *testing.T) {
+	for _, uri := range []string{
+		"localhost:27017",
+		"mongodb://db/?replicaSet=rs0&directConnection=true&connect=unknown",
+		"mongodb://db/?maxPoolSize=lots",
+		"mongodb://@db",
+	} {
+		if _, err := clientOptions(uri); err == nil {
+			t.Errorf("clientOptions(%q) accepted a malformed URI", uri)
+		}
+	}
+	if _, err := clientOptions("mongodb://db1,db2/?replicaSet=rs0&authSource=admin"); err != nil {
+		t.Errorf("clientOptions on a valid URI: %v", err)
+	}
+}
+
+func TestClientOptionsTimeouts(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.ServerSelectionTimeout = 3 * time.Second
+	config.ConnectTimeout = 2 * time.Second
+
+	// SERVER_SELECTION_TIMEOUT and CONNECT_TIMEOUT win over the URI's.
+	opts, err := clientOptions("mongodb://db/?serverSelectionTimeoutMS=60000&connectTimeoutMS=60000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.ServerSelectionTimeout == nil || *opts.ServerSelectionTimeout != 3*time.Second {
+		t.Errorf("server selection timeout = %v, want 3s", opts.ServerSelectionTimeout)
+	}
+	if opts.ConnectTimeout == nil || *opts.ConnectTimeout != 2*time.Second {
+		t.Errorf("connect timeout = %v, want 2s", opts.ConnectTimeout)
+	}
+}
+
+func TestGetPersonAt(t *testing.T) {
+	ns := Database + "." + Collection
+	get := func(n string) *httptest.ResponseRecorder {
+		r := mux.SetURLVars(httptest.NewRequest("GET", "/people/at/"+n, nil), map[string]string{"n": n})
+		rec := httptest.NewRecorder()
+		GetPersonAt(rec, r)
+		return rec
+	}
+
+	for _, n := range []string{"-1", "+1", "1.5", "two", "99999999999999999999"} {
+		if rec := get(n); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET /people/at/%s: status %d, want 400", n, rec.Code)
+		}
+	}
+
+	runMock(t, "found", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "name", Value: "Cy"}}))
+		rec := get("2")
+		var got Person
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); rec.Code != http.StatusOK || err != nil || got.Name != "Cy" {
+			mt.Fatalf("status %d, body %s; want Cy", rec.Code, rec.Body)
+		}
+		find := mt.GetStartedEvent().Command
+		if skip := find.Lookup("skip").AsInt64(); skip != 2 {
+			mt.Errorf("find %s skips %d, want 2", find, skip)
+		}
+		if _, err := find.LookupErr("sort"); err != nil {
+			mt.Errorf("find %s has no sort, so positions aren't stable", find)
+		}
+	})
+
+	runMock(t, "past the end", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch))
+		if rec := get("7"); rec.Code != http.StatusNotFound {
+			mt.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+}
+
+func TestPreserveManagedFields(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	updated := created.Add(time.Hour)
+	existing := Person{ID: primitive.NewObjectID(), Name: "Ann", Age: 30, CreatedAt: &created, CreatedBy: "alice"}
+	replacement := Person{Name: "Ann Lee", UpdatedAt: &updated, UpdatedBy: "bob"}
+
+	preserveManagedFields(&replacement, existing)
+	want := Person{ID: existing.ID, Name: "Ann Lee", CreatedAt: &created, CreatedBy: "alice", UpdatedAt: &updated, UpdatedBy: "bob"}
+	if !reflect.DeepEqual(replacement, want) {
+		t.Errorf("replacement = %+v, want %+v", replacement, want)
+	}
+}
+
+func TestUpdatePersonIDMismatch(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	ns := Database + "." + Collection
+	id, other := primitive.NewObjectID(), primitive.NewObjectID()
+	stored := bson.D{{Key: "_id", Value: id}, {Key: "name", Value: "Ann"}}
+	replaced := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: int32(1)}, bson.E{Key: "nModified", Value: int32(1)})
+
+	tests := []struct {
+		name, policy string
+		bodyID       primitive.ObjectID
+		responses    []bson.D
+		want         int
+	}{
+		{"reject, other id", idMismatchReject, other,
+			[]bson.D{mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, stored), mtest.CreateSuccessResponse()}, http.StatusConflict},
+		{"reject, same id", idMismatchReject, id,
+			[]bson.D{mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, stored), replaced, mtest.CreateSuccessResponse()}, http.StatusOK},
+		{"ignore, other id", idMismatchIgnore, other,
+			[]bson.D{mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, stored), replaced, mtest.CreateSuccessResponse()}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		config.IDMismatch = tt.policy
+		runMock(t, tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(tt.responses...)
+			body := fmt.Sprintf(`{"id":%q,"name":"Ann Lee"}`, tt.bodyID.Hex())
+			r := mux.SetURLVars(httptest.NewRequest("PUT", "/people/"+id.Hex(), strings.NewReader(body)), map[string]string{"id": id.Hex()})
+			rec := httptest.NewRecorder()
+			UpdatePerson(rec, r)
+			if rec.Code != tt.want {
+				mt.Fatalf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			var got Person
+			if rec.Code == http.StatusOK && (json.Unmarshal(rec.Body.Bytes(), &got) != nil || got.ID != id) {
+				mt.Errorf("response %s, want the person at the path id", rec.Body)
+			}
+		})
+	}
+}
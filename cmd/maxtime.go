@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type maxTimeKey struct{}
+
+// maxTimes are the server-side time limits for a request's queries.
+type maxTimes struct {
+	query, stream time.Duration
+}
+
+// maxTimeMiddleware reads the max_time_ms parameter, which bounds how long
+// list and aggregate queries may run on the server, and stores it in the
+// request context. It is clamped to config.QueryMaxTime, which also applies
+// when the parameter is absent. Streamed responses use
+// config.StreamMaxTime instead, which the parameter can shorten too.
+func maxTimeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limits := maxTimes{query: config.QueryMaxTime, stream: config.StreamMaxTime}
+		if v := r.URL.Query().Get("max_time_ms"); v != "" {
+			ms, err := strconv.Atoi(v)
+			if err != nil || ms < 1 {
+				handleClientError(w, http.StatusBadRequest, "max_time_ms must be a positive integer")
+				return
+			}
+			requested := time.Duration(ms) * time.Millisecond
+			limits.query = shorterLimit(limits.query, requested)
+			limits.stream = shorterLimit(limits.stream, requested)
+		}
+		if limits != (maxTimes{}) {
+			r = r.WithContext(context.WithValue(r.Context(), maxTimeKey{}, limits))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shorterLimit returns the shorter of two time limits, where 0 is none.
+func shorterLimit(limit, requested time.Duration) time.Duration {
+	if limit == 0 || requested < limit {
+		return requested
+	}
+	return limit
+}
+
+// queryMaxTime returns the server-side time limit for queries made on
+// behalf of the request, or 0 for none.
+func queryMaxTime(ctx context.Context) time.Duration {
+	limits, _ := ctx.Value(maxTimeKey{}).(maxTimes)
+	return limits.query
+}
+
+// streamMaxTime is queryMaxTime for queries whose results are streamed.
+func streamMaxTime(ctx context.Context) time.Duration {
+	limits, _ := ctx.Value(maxTimeKey{}).(maxTimes)
+	return limits.stream
+}
+
+func findOptions(ctx context.Context) *options.FindOptions {
+	opts := options.Find().SetAllowDiskUse(config.AllowDiskUse)
+	if d := queryMaxTime(ctx); d > 0 {
+		opts.SetMaxTime(d)
+	}
+	return opts
+}
+
+// streamFindOptions is findOptions for finds whose results are streamed
+// out as they arrive, which use config.FindBatchSize and the stream time
+// limit.
+func streamFindOptions(ctx context.Context) *options.FindOptions {
+	opts := options.Find().SetAllowDiskUse(config.AllowDiskUse)
+	if d := streamMaxTime(ctx); d > 0 {
+		opts.SetMaxTime(d)
+	}
+	if config.FindBatchSize > 0 {
+		opts.SetBatchSize(int32(config.FindBatchSize))
+	}
+	return opts
+}
+
+func findOneOptions(ctx context.Context) *options.FindOneOptions {
+	opts := options.FindOne()
+	if d := queryMaxTime(ctx); d > 0 {
+		opts.SetMaxTime(d)
+	}
+	return opts
+}
+
+func countOptions(ctx context.Context) *options.CountOptions {
+	opts := options.Count()
+	if d := queryMaxTime(ctx); d > 0 {
+		opts.SetMaxTime(d)
+	}
+	return opts
+}
+
+// aggregateOptions returns the options shared by every aggregation.
+func aggregateOptions(ctx context.Context) *options.AggregateOptions {
+	opts := options.Aggregate().SetAllowDiskUse(config.AllowDiskUse)
+	if d := queryMaxTime(ctx); d > 0 {
+		opts.SetMaxTime(d)
+	}
+	return opts
+}
+
+// streamAggregateOptions is aggregateOptions for streamed aggregations.
+func streamAggregateOptions(ctx context.Context) *options.AggregateOptions {
+	opts := options.Aggregate().SetAllowDiskUse(config.AllowDiskUse)
+	if d := streamMaxTime(ctx); d > 0 {
+		opts.SetMaxTime(d)
+	}
+	if config.FindBatchSize > 0 {
+		opts.SetBatchSize(int32(config.FindBatchSize))
+	}
+	return opts
+}
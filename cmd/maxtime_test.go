@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMaxTimeMiddleware(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+
+	var query, stream time.Duration
+	handler := maxTimeMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, stream = queryMaxTime(r.Context()), streamMaxTime(r.Context())
+	}))
+	tests := []struct {
+		queryLimit, streamLimit time.Duration
+		params                  string
+		query, stream           time.Duration
+		code                    int
+	}{
+		{30 * time.Second, 0, "", 30 * time.Second, 0, http.StatusOK},
+		{30 * time.Second, 0, "max_time_ms=500", 500 * time.Millisecond, 500 * time.Millisecond, http.StatusOK},
+		// The parameter can only shorten the configured limits.
+		{time.Second, time.Minute, "max_time_ms=5000", time.Second, 5 * time.Second, http.StatusOK},
+		{time.Second, 2 * time.Second, "max_time_ms=5000", time.Second, 2 * time.Second, http.StatusOK},
+		{0, 0, "max_time_ms=5000", 5 * time.Second, 5 * time.Second, http.StatusOK},
+		{0, time.Minute, "", 0, time.Minute, http.StatusOK},
+		{0, 0, "", 0, 0, http.StatusOK},
+		{time.Second, 0, "max_time_ms=0", 0, 0, http.StatusBadRequest},
+		{time.Second, 0, "max_time_ms=soon", 0, 0, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		config.QueryMaxTime, config.StreamMaxTime = tt.queryLimit, tt.streamLimit
+		query, stream = 0, 0
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/people?"+tt.params, nil))
+		if rec.Code != tt.code || query != tt.query || stream != tt.stream {
+			t.Errorf("QUERY_MAX_TIME %s, STREAM_MAX_TIME %s, %q: status %d, limits %s and %s; want %d, %s and %s",
+				tt.queryLimit, tt.streamLimit, tt.params, rec.Code, query, stream, tt.code, tt.query, tt.stream)
+		}
+	}
+}
+
+func TestStreamOptionsMaxTime(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.QueryMaxTime = 30 * time.Second
+
+	// Streams don't inherit the query limit.
+	config.StreamMaxTime = 0
+	ctx := requestMaxTimes()
+	if got := streamFindOptions(ctx).MaxTime; got != nil {
+		t.Errorf("streamFindOptions max time = %s, want none", *got)
+	}
+	if got := streamAggregateOptions(ctx).MaxTime; got != nil {
+		t.Errorf("streamAggregateOptions max time = %s, want none", *got)
+	}
+	if got := findOptions(ctx).MaxTime; got == nil || *got != 30*time.Second {
+		t.Errorf("findOptions max time = %v, want 30s", got)
+	}
+
+	config.StreamMaxTime = 10 * time.Minute
+	ctx = requestMaxTimes()
+	if got := streamFindOptions(ctx).MaxTime; got == nil || *got != 10*time.Minute {
+		t.Errorf("streamFindOptions max time = %v, want 10m", got)
+	}
+	if got := streamAggregateOptions(ctx).MaxTime; got == nil || *got != 10*time.Minute {
+		t.Errorf("streamAggregateOptions max time = %v, want 10m", got)
+	}
+}
+
+// requestMaxTimes returns the context maxTimeMiddleware gives a request
+// without max_time_ms.
+func requestMaxTimes() context.Context {
+	var ctx context.Context
+	handler := maxTimeMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/people", nil))
+	return ctx
+}
+
+func TestStreamOptionsBatchSize(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	ctx := context.Background()
+
+	config.FindBatchSize = 250
+	if got := streamFindOptions(ctx).BatchSize; got == nil || *got != 250 {
+		t.Errorf("streamFindOptions batch size = %v, want 250", got)
+	}
+	if got := streamAggregateOptions(ctx).BatchSize; got == nil || *got != 250 {
+		t.Errorf("streamAggregateOptions batch size = %v, want 250", got)
+	}
+	// Paginated queries fetch one page and keep the server's default.
+	if got := findOptions(ctx).BatchSize; got != nil {
+		t.Errorf("findOptions batch size = %d, want none", *got)
+	}
+
+	config.FindBatchSize = 0
+	if got := streamFindOptions(ctx).BatchSize; got != nil {
+		t.Errorf("streamFindOptions batch size with FIND_BATCH_SIZE=0 = %d, want none", *got)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+)
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+func GetVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]string{
+		"version": version,
+		"go":      runtime.Version(),
+	})
+}
+
+// GetCapabilities lists the optional features enabled in this deployment.
+func GetCapabilities(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"gzip":              true,
+		"ext_json":          true,
+		"estimated_count":   true,
+		"natural_key":       config.NaturalKey,
+		"normalize_email":   config.NormalizeEmail,
+		"server_api_strict": config.ServerAPIStrict,
+		"max_page_size":     config.MaxPageSize,
+		"read_only":         config.ReadOnly,
+		"soft_delete":       config.SoftDelete,
+	})
+}
+
+type schemaField struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Required   bool   `json:"required"`
+	Filterable bool   `json:"filterable"`
+	Sortable   bool   `json:"sortable"`
+
+	// Managed fields are set by the server; values sent by clients are
+	// dropped.
+	Managed bool `json:"managed"`
+}
+
+// personSchema lists the Person fields by their JSON name.
+var personSchema = []schemaField{
+	{Name: "id", Type: "objectid"},
+	{Name: "name", Type: "string", Required: true},
+	{Name: "age", Type: "integer"},
+	{Name: "address", Type: "string"},
+	{Name: "email", Type: "string"},
+	{Name: "tags", Type: "array<string>"},
+	{Name: "created_at", Type: "datetime", Managed: true},
+	{Name: "updated_at", Type: "datetime", Managed: true},
+	{Name: "created_by", Type: "string", Managed: true},
+	{Name: "updated_by", Type: "string", Managed: true},
+	{Name: "deleted_at", Type: "datetime", Managed: true},
+}
+
+func isPersonField(name string) bool {
+	_, ok := personFieldType(name)
+	return ok
+}
+
+// personFieldType returns the schema type of the Person field name.
+func personFieldType(name string) (string, bool) {
+	for _, f := range personSchema {
+		if f.Name == name {
+			return f.Type, true
+		}
+	}
+	return "", false
+}
+
+// GetPersonSchema describes the Person fields and how they can be queried.
+func GetPersonSchema(w http.ResponseWriter, r *http.Request) {
+	fields := append([]schemaField(nil), personSchema...)
+	for i := range fields {
+		fields[i].Filterable = filterFields[fields[i].Name]
+		_, fields[i].Sortable = sortFields[fields[i].Name]
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"fields": fields})
+}
+
+// noStoreMiddleware marks responses as uncacheable unless the handler says
+// otherwise; people data changes with every write.
+func noStoreMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cacheable lets clients and proxies cache a handler's successful
+// responses for config.StaticMaxAge. Errors keep noStoreMiddleware's
+// no-store, so a passing failure isn't served from caches. Only use it for
+// data that is fixed per deployment.
+func cacheable(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(&cacheWriter{ResponseWriter: w}, r)
+	}
+}
+
+// cacheWriter sets cacheable's Cache-Control header when the response
+// status turns out to be 2xx.
+type cacheWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (c *cacheWriter) WriteHeader(status int) {
+	if !c.wroteHeader && status >= 200 && status < 300 {
+		c.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(config.StaticMaxAge.Seconds())))
+	}
+	c.wroteHeader = true
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *cacheWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	return c.ResponseWriter.Write(p)
+}
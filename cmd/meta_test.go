@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheable(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.StaticMaxAge = time.Hour
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"implicit 200", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("{}")) }, "public, max-age=3600"},
+		{"204", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }, "public, max-age=3600"},
+		{"500", func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusInternalServerError, "spec failed to load")
+		}, "no-store"},
+		{"503", func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusServiceUnavailable, "database unavailable")
+		}, "no-store"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		noStoreMiddleware(cacheable(tt.handler)).ServeHTTP(rec, httptest.NewRequest("GET", "/version", nil))
+		if got := rec.Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("%s: Cache-Control %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type requestIDKey struct{}
+
+// requestIDMiddleware takes the request id from the first of
+// config.RequestIDHeaders the client sent, or generates one, stores it in
+// the request context and echoes it in config.RequestIDResponseHeader.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := incomingRequestID(r)
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set(config.RequestIDResponseHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func incomingRequestID(r *http.Request) string {
+	for _, name := range config.RequestIDHeaders {
+		v := strings.TrimSpace(r.Header.Get(name))
+		if strings.EqualFold(name, "traceparent") {
+			// version-traceid-parentid-flags; the trace id is the part
+			// shared by every hop.
+			if parts := strings.Split(v, "-"); len(parts) == 4 {
+				v = parts[1]
+			} else {
+				v = ""
+			}
+		}
+		if validRequestID(v) {
+			return v
+		}
+	}
+	return ""
+}
+
+// validRequestID keeps client ids short and printable, since they end up
+// in logs and response headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the id assigned to the request by requestIDMiddleware.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// bodyLogMiddleware logs request and response bodies, truncated to
+// config.BodyLogMaxBytes and with the values of config.BodyLogRedact
+// fields masked. Streamed responses are passed through untouched.
+func bodyLogMiddleware(next http.Handler) http.Handler {
+	redact := redactPattern(config.BodyLogRedact)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqBody []byte
+		if r.Body != nil {
+			var err error
+			reqBody, err = io.ReadAll(io.LimitReader(r.Body, int64(config.BodyLogMaxBytes)))
+			if err != nil {
+				handleError(w, err)
+				return
+			}
+			// Hand the handler the bytes we consumed followed by the rest.
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(reqBody), r.Body), r.Body}
+		}
+
+		rec := &bodyRecorder{ResponseWriter: w, max: config.BodyLogMaxBytes, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		resBody := "[stream]"
+		if !rec.streamed {
+			resBody = redactBody(rec.buf.Bytes(), redact)
+		}
+		log.Printf("[%s] %s %s request=%s response(%d)=%s", requestID(r.Context()),
+			r.Method, r.URL.RequestURI(), redactBody(reqBody, redact), rec.status, resBody)
+	})
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// bodyRecorder copies up to max bytes of the response body aside.
+type bodyRecorder struct {
+	http.ResponseWriter
+	buf         bytes.Buffer
+	max         int
+	status      int
+	wroteHeader bool
+	streamed    bool
+}
+
+func (b *bodyRecorder) WriteHeader(status int) {
+	if b.wroteHeader {
+		return
+	}
+	b.wroteHeader = true
+	b.status = status
+	if strings.HasPrefix(b.Header().Get("Content-Type"), "text/event-stream") {
+		b.streamed = true
+	}
+	b.ResponseWriter.WriteHeader(status)
+}
+
+func (b *bodyRecorder) Write(p []byte) (int, error) {
+	if !b.wroteHeader {
+		b.WriteHeader(http.StatusOK)
+	}
+	if !b.streamed {
+		if room := b.max - b.buf.Len(); room > 0 {
+			if len(p) < room {
+				room = len(p)
+			}
+			b.buf.Write(p[:room])
+		}
+	}
+	return b.ResponseWriter.Write(p)
+}
+
+func (b *bodyRecorder) Flush() {
+	b.streamed = true
+	if f, ok := b.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (b *bodyRecorder) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
+
+// redactPattern matches JSON members whose key is one of fields. It works
+// on truncated bodies too, which a JSON decoder would reject.
+func redactPattern(fields []string) *regexp.Regexp {
+	if len(fields) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(fields))
+	for i, f := range fields {
+		quoted[i] = regexp.QuoteMeta(f)
+	}
+	return regexp.MustCompile(`(?i)("(?:` + strings.Join(quoted, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`)
+}
+
+func redactBody(body []byte, redact *regexp.Regexp) string {
+	if redact == nil {
+		return string(body)
+	}
+	return redact.ReplaceAllString(string(body), `${1}"[REDACTED]"`)
+}
+
+// gzipPools holds idle gzip writers by compression level. A writer keeps
+// its level across Reset, so one pool per level keeps a pooled writer from
+// outliving a change to config.GzipLevel.
+var gzipPools [gzip.BestCompression + 1]sync.Pool
+
+// gzipMiddleware compresses responses for clients that accept gzip, using
+// config.GzipLevel.
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc, q, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.EqualFold(strings.TrimSpace(enc), "gzip") && strings.ReplaceAll(q, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter starts compressing once the status is known, so that
+// bodiless responses such as 204 and 304 are left alone.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	level       int
+	wroteHeader bool
+}
+
+// newGzipWriter returns a writer compressing to w at level, which
+// loadConfig has validated.
+func newGzipWriter(w io.Writer, level int) *gzip.Writer {
+	if gz, ok := gzipPools[level].Get().(*gzip.Writer); ok {
+		gz.Reset(w)
+		return gz
+	}
+	gz, _ := gzip.NewWriterLevel(w, level)
+	return gz
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	h := g.Header()
+	if status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		g.level = config.GzipLevel
+		g.gz = newGzipWriter(g.ResponseWriter, g.level)
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz == nil {
+		return g.ResponseWriter.Write(p)
+	}
+	return g.gz.Write(p)
+}
+
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) Close() {
+	if g.gz == nil {
+		return
+	}
+	g.gz.Close()
+	gzipPools[g.level].Put(g.gz)
+	g.gz = nil
+}
+
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// readOnlyPosts are POST endpoints that only read, and so stay available in
+// read-only mode.
+var readOnlyPosts = map[string]bool{
+	"/people/search":              true,
+	"/people/bulk-update/preview": true,
+}
+
+// readOnlyMiddleware rejects requests that could write when config.ReadOnly
+// is set. Reads are served as usual.
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if r.Method != http.MethodPost || !readOnlyPosts[r.URL.Path] {
+				// Read-only mode lasts until a restart, so retrying won't help.
+				writePermanentError(w, http.StatusServiceUnavailable, "the service is in read-only mode; writes are disabled")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRedactBody(t *testing.T) {
+	redact := redactPattern([]string{"password", "api_key"})
+	tests := []struct {
+		body, want string
+	}{
+		{`{"name":"Ann","password":"hunter2"}`, `{"name":"Ann","password":"[REDACTED]"}`},
+		{`{"Password" : "a \"quoted\" one"}`, `{"Password" : "[REDACTED]"}`},
+		{`{"api_key":12345,"age":3}`, `{"api_key":"[REDACTED]","age":3}`},
+		{`[{"password":null}]`, `[{"password":"[REDACTED]"}]`},
+		// A body cut off mid-value is still redacted.
+		{`{"password":"hunt`, `{"password":"[REDACTED]"`},
+		{`{"name":"password"}`, `{"name":"password"}`},
+	}
+	for _, tt := range tests {
+		if got := redactBody([]byte(tt.body), redact); got != tt.want {
+			t.Errorf("redactBody(%s) = %s, want %s", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestRedactBodyWithoutFields(t *testing.T) {
+	body := `{"password":"hunter2"}`
+	if got := redactBody([]byte(body), redactPattern(nil)); got != body {
+		t.Errorf("redactBody with no fields = %s, want it unchanged", got)
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.RequestIDHeaders = []string{"X-Correlation-ID", "traceparent"}
+	config.RequestIDResponseHeader = "X-Trace-ID"
+
+	var seen string
+	handler := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestID(r.Context())
+	}))
+	tests := []struct {
+		header http.Header
+		want   string
+	}{
+		{http.Header{"X-Correlation-Id": {"abc-123"}}, "abc-123"},
+		// The first header with a usable id wins.
+		{http.Header{"X-Correlation-Id": {"has space"}, "Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}},
+			"4bf92f3577b34da6a3ce929d0e0e4736"},
+		{http.Header{"Traceparent": {"garbage"}}, ""},
+		{http.Header{"X-Request-Id": {"not-configured"}}, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/people", nil)
+		r.Header = tt.header
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+
+		got := rec.Header().Get("X-Trace-ID")
+		if got != seen {
+			t.Errorf("with %v: response header %q, context %q", tt.header, got, seen)
+		}
+		switch {
+		case tt.want != "" && got != tt.want:
+			t.Errorf("with %v: request id %q, want %q", tt.header, got, tt.want)
+		case tt.want == "" && len(got) != 32:
+			t.Errorf("with %v: request id %q, want a generated one", tt.header, got)
+		}
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	long := make([]byte, 129)
+	for i := range long {
+		long[i] = 'a'
+	}
+	for id, want := range map[string]bool{
+		"abc-123":          true,
+		string(long[:128]): true,
+		string(long):       false,
+		"":                 false,
+		"a b":              false,
+		"a\nb":             false,
+		"a\x00":            false,
+		"café":             false,
+	} {
+		if got := validRequestID(id); got != want {
+			t.Errorf("validRequestID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestReadOnlyMiddleware(t *testing.T) {
+	handler := readOnlyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/people", http.StatusOK},
+		{"HEAD", "/people/x", http.StatusOK},
+		{"POST", "/people/search", http.StatusOK},
+		{"POST", "/people/bulk-update/preview", http.StatusOK},
+		{"POST", "/people", http.StatusServiceUnavailable},
+		{"PUT", "/people/x", http.StatusServiceUnavailable},
+		{"DELETE", "/people/x", http.StatusServiceUnavailable},
+		{"POST", "/people/tag", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+			continue
+		}
+		if rec.Code == http.StatusServiceUnavailable {
+			// Read-only mode lasts until a restart, so clients are told
+			// not to retry.
+			if body := decodeErrorBody(t, rec); body.Retry.Retryable || rec.Header().Get("Retry-After") != "" {
+				t.Errorf("%s %s: body %+v, Retry-After %q; want not retryable", tt.method, tt.path, body, rec.Header().Get("Retry-After"))
+			}
+		}
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, want := range map[string]bool{
+		"gzip":                true,
+		"br, GZIP;q=0.5":      true,
+		"deflate, gzip ; q=0": false,
+		"identity":            false,
+		"":                    false,
+	} {
+		r := httptest.NewRequest("GET", "/people", nil)
+		r.Header.Set("Accept-Encoding", header)
+		if got := acceptsGzip(r); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	body := strings.Repeat(`{"name":"Ann"}`, 100)
+	handler := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	rec := serve("/people")
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", rec.Header().Get("Content-Encoding"))
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(gz); err != nil || string(got) != body {
+		t.Errorf("decompressed body = %q, %v", got, err)
+	}
+
+	rec = serve("/empty")
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() != 0 {
+		t.Errorf("204 was compressed: Content-Encoding %q, %d body bytes", rec.Header().Get("Content-Encoding"), rec.Body.Len())
+	}
+}
+
+func TestGzipLevelConfig(t *testing.T) {
+	for _, level := range []string{"0", "10", "fast"} {
+		t.Setenv("GZIP_LEVEL", level)
+		if _, err := loadConfig(); err == nil {
+			t.Errorf("loadConfig accepted GZIP_LEVEL=%s", level)
+		}
+	}
+	t.Setenv("GZIP_LEVEL", "9")
+	if c, err := loadConfig(); err != nil || c.GzipLevel != 9 {
+		t.Errorf("loadConfig with GZIP_LEVEL=9 = %d, %v", c.GzipLevel, err)
+	}
+}
+
+func TestGzipMiddlewareLevel(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	handler := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat(`{"name":"Ann","age":30}`, 100)))
+	}))
+
+	// The gzip header's XFL byte records the fastest and best levels, so a
+	// pooled writer still at the old level would show.
+	for _, tt := range []struct {
+		level int
+		xfl   byte
+	}{{gzip.BestSpeed, 4}, {gzip.BestCompression, 2}, {gzip.BestSpeed, 4}} {
+		config.GzipLevel = tt.level
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/people", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		handler.ServeHTTP(rec, r)
+		if body := rec.Body.Bytes(); len(body) < 10 || body[8] != tt.xfl {
+			t.Errorf("GZIP_LEVEL=%d: gzip header %x, want XFL %d", tt.level, body[:min(len(body), 10)], tt.xfl)
+		}
+	}
+}
+
+func TestBodyLogMiddleware(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.BodyLogMaxBytes = 16
+	config.BodyLogRedact = []string{"password"}
+	var logged strings.Builder
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	var received string
+	handler := bodyLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+		if r.URL.Path == "/people/stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: {\"name\":\"Ann\"}\n\n")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"name":"Ann","password":"hunter2","age":30}`)
+	}))
+
+	body := `{"password":"hunter2","name":"Ann"}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/people", strings.NewReader(body)))
+	if received != body {
+		t.Errorf("handler read %q, want the whole body %q", received, body)
+	}
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), "hunter2") {
+		t.Errorf("response %d %q, want it passed through unchanged", rec.Code, rec.Body)
+	}
+	line := logged.String()
+	if strings.Contains(line, "hunter2") {
+		t.Errorf("log line %q has the password", line)
+	}
+	// Both bodies are cut off at 16 bytes.
+	if !strings.Contains(line, `request={"password":"[REDACTED]"`) ||
+		!strings.Contains(line, "response(201)={\"name\":\"Ann\",\"p\n") {
+		t.Errorf("log line %q, want both bodies truncated and redacted", line)
+	}
+
+	logged.Reset()
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/people/stream", nil))
+	if !strings.Contains(rec.Body.String(), "data: ") {
+		t.Errorf("stream body = %q, want it passed through", rec.Body)
+	}
+	if line := logged.String(); !strings.Contains(line, "response(200)=[stream]") {
+		t.Errorf("log line %q, want the stream left out", line)
+	}
+}
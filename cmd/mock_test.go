@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// runMock runs f as the subtest name, with client connected to a mocked
+// deployment instead of MongoDB. The deployment answers each command with
+// the next response queued by mt.AddMockResponses, and mt records the
+// commands sent.
+func runMock(t *testing.T, name string, f func(mt *mtest.T)) {
+	t.Helper()
+	mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock)).Run(name, func(mt *mtest.T) {
+		defer func(c *mongo.Client) { client = c }(client)
+		client = mt.Client
+		f(mt)
+	})
+}
+
+// countResponse answers the aggregation CountDocuments runs.
+func countResponse(n int64) bson.D {
+	return mtest.CreateCursorResponse(0, Database+"."+Collection, mtest.FirstBatch, bson.D{{Key: "n", Value: n}})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Address policies for people without an address. Clients may send "",
+// null or leave the field out; all three are stored the same way.
+const (
+	// addressOmit stores no address field at all, so {"address":
+	// {"$exists": false}} and ?missing=address find every such person.
+	addressOmit = "omit"
+	// addressEmpty stores an empty string.
+	addressEmpty = "empty"
+)
+
+// normalizePerson puts client supplied fields into their canonical stored
+// form. It runs on every write, before validation and uniqueness checks.
+func normalizePerson(p *Person) {
+	if config.NormalizeEmail {
+		p.Email = normalizeEmail(p.Email)
+	}
+	p.Tags = normalizeTags(p.Tags)
+}
+
+// normalizeTags drops repeated tags, keeping the first occurrence, and
+// sorts the rest when config.SortTags is set, so equal tag sets are stored
+// as equal arrays.
+func normalizeTags(tags []string) []string {
+	if tags == nil {
+		return nil
+	}
+	seen := make(map[string]bool, len(tags))
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if !seen[tag] {
+			seen[tag] = true
+			out = append(out, tag)
+		}
+	}
+	if config.SortTags {
+		sort.Strings(out)
+	}
+	return out
+}
+
+// normalizeEmail trims and lowercases an email address, so that case
+// variants collide on the unique email index.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Input policies for text fields containing control characters or
+// surrounding whitespace.
+const (
+	// inputSanitize strips control characters and trims the value. This
+	// is the default.
+	inputSanitize = "sanitize"
+	// inputReject refuses the request with a 400 instead.
+	inputReject = "reject"
+)
+
+// sanitizePerson cleans the text fields of p: control characters are
+// removed and surrounding whitespace trimmed. Under the reject policy no
+// field is changed, and an error names every field that would have been.
+func sanitizePerson(p *Person) error {
+	var c textCleaner
+	c.clean("name", &p.Name)
+	c.clean("address", &p.Address)
+	c.clean("email", &p.Email)
+	c.cleanTags("tags", p.Tags)
+	return c.err()
+}
+
+// sanitizeTags is sanitizePerson for tags sent on their own. name is the
+// request field that held them, for the error message.
+func sanitizeTags(name string, tags []string) error {
+	var c textCleaner
+	c.cleanTags(name, tags)
+	return c.err()
+}
+
+// textCleaner applies config.InputMode to a request's text fields, noting
+// the ones it would have to change under the reject policy.
+type textCleaner struct {
+	dirty []string
+}
+
+func (c *textCleaner) clean(name string, v *string) {
+	s := sanitizeText(*v)
+	if s == *v {
+		return
+	}
+	if config.InputMode == inputReject {
+		c.dirty = append(c.dirty, name)
+		return
+	}
+	*v = s
+}
+
+func (c *textCleaner) cleanTags(name string, tags []string) {
+	for i := range tags {
+		c.clean(fmt.Sprintf("%s[%d]", name, i), &tags[i])
+	}
+}
+
+func (c *textCleaner) err() error {
+	if len(c.dirty) > 0 {
+		return fmt.Errorf("control characters or surrounding whitespace in: %s", strings.Join(c.dirty, ", "))
+	}
+	return nil
+}
+
+func sanitizeText(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}
+
+// storedPerson returns the document written for p, with an empty address
+// in its canonical form and the computed fields filled in.
+func storedPerson(p Person) (bson.M, error) {
+	raw, err := bson.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if p.Address == "" && config.AddressMode == addressEmpty {
+		doc["address"] = ""
+	}
+	for name, f := range computedFields {
+		doc[name] = f.compute(p)
+	}
+	return doc, nil
+}
+
+// personUpdate returns an update setting every field of p. In omit mode an
+// empty address is $unset, so that replacing a person's address with
+// nothing also cleans up the stored field.
+func personUpdate(p Person) (bson.M, error) {
+	doc, err := storedPerson(p)
+	if err != nil {
+		return nil, err
+	}
+	update := bson.M{"$set": doc}
+	if p.Address == "" && config.AddressMode == addressOmit {
+		update["$unset"] = bson.M{"address": ""}
+	}
+	return update, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSanitizePerson(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.InputMode = inputSanitize
+
+	p := Person{Name: " Ann\x00 ", Address: "1 Main St\r\n", Email: "ann@example.com", Tags: []string{"\tvip", "ok"}}
+	if err := sanitizePerson(&p); err != nil {
+		t.Fatal(err)
+	}
+	want := Person{Name: "Ann", Address: "1 Main St", Email: "ann@example.com", Tags: []string{"vip", "ok"}}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("sanitizePerson = %+v, want %+v", p, want)
+	}
+}
+
+func TestSanitizePersonReject(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.InputMode = inputReject
+
+	p := Person{Name: "Ann ", Address: "1 Main St", Tags: []string{"ok", "bad\x07"}}
+	before := Person{Name: p.Name, Address: p.Address, Tags: append([]string(nil), p.Tags...)}
+	err := sanitizePerson(&p)
+	if err == nil {
+		t.Fatal("sanitizePerson accepted dirty input under the reject policy")
+	}
+	if !strings.HasSuffix(err.Error(), ": name, tags[1]") {
+		t.Errorf("sanitizePerson error = %q, want it to name name and tags[1]", err)
+	}
+	if !reflect.DeepEqual(p, before) {
+		t.Errorf("sanitizePerson changed %+v to %+v under the reject policy", before, p)
+	}
+
+	clean := Person{Name: "Ann", Tags: []string{"ok"}}
+	if err := sanitizePerson(&clean); err != nil {
+		t.Errorf("sanitizePerson rejected clean input: %v", err)
+	}
+}
+
+func TestSanitizeTags(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+
+	config.InputMode = inputSanitize
+	tags := []string{" a", "b\n"}
+	if err := sanitizeTags("tags", tags); err != nil || !reflect.DeepEqual(tags, []string{"a", "b"}) {
+		t.Errorf("sanitizeTags = %q, %v; want [a b], nil", tags, err)
+	}
+
+	config.InputMode = inputReject
+	if err := sanitizeTags("tags", []string{"a", " b"}); err == nil || !strings.Contains(err.Error(), "tags[1]") {
+		t.Errorf("sanitizeTags error = %v, want it to name tags[1]", err)
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	tests := []struct {
+		tags []string
+		sort bool
+		want []string
+	}{
+		{[]string{"b", "a", "b", "c", "a"}, false, []string{"b", "a", "c"}},
+		{[]string{"b", "a", "b", "c", "a"}, true, []string{"a", "b", "c"}},
+		// Case variants are different tags.
+		{[]string{"VIP", "vip"}, true, []string{"VIP", "vip"}},
+		{[]string{}, true, []string{}},
+		{nil, true, nil},
+	}
+	for _, tt := range tests {
+		config.SortTags = tt.sort
+		if got := normalizeTags(tt.tags); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("normalizeTags(%q) with SortTags=%v = %q, want %q", tt.tags, tt.sort, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+
+	config.NormalizeEmail = true
+	p := Person{Email: "  Ann@Example.COM "}
+	normalizePerson(&p)
+	if p.Email != "ann@example.com" {
+		t.Errorf("normalized email = %q, want ann@example.com", p.Email)
+	}
+
+	config.NormalizeEmail = false
+	p = Person{Email: "Ann@Example.COM"}
+	normalizePerson(&p)
+	if p.Email != "Ann@Example.COM" {
+		t.Errorf("email = %q with NORMALIZE_EMAIL off, want it unchanged", p.Email)
+	}
+}
+
+func TestStoredPersonAddress(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+
+	config.AddressMode = addressOmit
+	doc, err := storedPerson(Person{Name: "Ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc["address"]; ok {
+		t.Errorf("omit mode stored address %q", doc["address"])
+	}
+	update, err := personUpdate(Person{Name: "Ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unset, _ := update["$unset"].(bson.M); unset == nil || unset["address"] == nil {
+		t.Errorf("omit mode update = %v, want the address unset", update)
+	}
+
+	config.AddressMode = addressEmpty
+	doc, err = storedPerson(Person{Name: "Ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address, ok := doc["address"]; !ok || address != "" {
+		t.Errorf("empty mode stored address %v, %v; want an empty string", address, ok)
+	}
+	if update, _ := personUpdate(Person{Name: "Ann"}); update["$unset"] != nil {
+		t.Errorf("empty mode update = %v, want nothing unset", update)
+	}
+
+	doc, _ = storedPerson(Person{Name: "Ann", Address: "1 Main St"})
+	if doc["address"] != "1 Main St" {
+		t.Errorf("stored address = %v, want 1 Main St", doc["address"])
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	_ "embed"
+	"log"
+	"net/http"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// openAPISpec is the OpenAPI document for this service. It is maintained as
+// JSON; the YAML form is generated from it so the two can't drift.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+var openAPIYAML = sync.OnceValues(func() ([]byte, error) {
+	// JSON is a subset of YAML, and decoding into a MapSlice keeps the keys
+	// in the order they appear in openapi.json.
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(openAPISpec, &doc); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+})
+
+func GetOpenAPIJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+func GetOpenAPIYAML(w http.ResponseWriter, r *http.Request) {
+	spec, err := openAPIYAML()
+	if err != nil {
+		log.Println("Error converting OpenAPI spec to YAML:", err)
+		handleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(spec)
+}
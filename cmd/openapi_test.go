@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// jsonValue converts a value decoded by yaml.v2 into what encoding/json
+// decodes the same document to.
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case yaml.MapSlice:
+		m := map[string]interface{}{}
+		for _, item := range t {
+			m[fmt.Sprint(item.Key)] = jsonValue(item.Value)
+		}
+		return m
+	case []interface{}:
+		for i := range t {
+			t[i] = jsonValue(t[i])
+		}
+		return t
+	case int:
+		return float64(t)
+	}
+	return v
+}
+
+func TestOpenAPIYAMLMatchesJSON(t *testing.T) {
+	spec, err := openAPIYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromYAML yaml.MapSlice
+	if err := yaml.Unmarshal(spec, &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON interface{}
+	if err := json.Unmarshal(openAPISpec, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(jsonValue(fromYAML), fromJSON) {
+		t.Error("the YAML spec differs from openapi.json")
+	}
+
+	rec := httptest.NewRecorder()
+	GetOpenAPIYAML(rec, httptest.NewRequest("GET", "/openapi.yaml", nil))
+	if rec.Header().Get("Content-Type") != "application/yaml" || !bytes.Equal(rec.Body.Bytes(), spec) {
+		t.Errorf("GET /openapi.yaml: Content-Type %q, %d bytes; want the YAML spec", rec.Header().Get("Content-Type"), rec.Body.Len())
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// commonParams are accepted by every endpoint.
+var commonParams = []string{"ext_json", "max_time_ms", "strict", "tz"}
+
+// peopleQueryParams are the parameters read by parsePeopleQuery. "filter[]"
+// stands for every filter[...] parameter.
+var peopleQueryParams = []string{
+	"ids", "name", "address", "min_age", "max_age", "min_age_exclusive", "max_age_exclusive",
+	"tags", "missing", "has", "where", "filter[]", "sort", "page", "page_size", "after", "estimate",
+}
+
+// peopleFilterParams are the peopleQueryParams that select people, plus
+// pagination, for endpoints that choose their own sort.
+var peopleFilterParams = []string{
+	"ids", "name", "address", "min_age", "max_age", "min_age_exclusive", "max_age_exclusive",
+	"tags", "missing", "has", "where", "filter[]", "page", "page_size", "after",
+}
+
+// routeParams lists the query parameters each route reads, keyed by method
+// and path template. Routes not listed take only commonParams. Keep this
+// in step with the handlers, or strict mode rejects valid requests.
+var routeParams = map[string][]string{
+	"GET /people":             peopleQueryParams,
+	"GET /people/export.csv":  append([]string{"fields"}, peopleQueryParams...),
+	"GET /people/percentiles": {"field", "p"},
+	"GET /people/top":         append([]string{"field", "percent"}, peopleFilterParams...),
+	"GET /people/group-by":    {"field", "format"},
+	"GET /people/trash":       peopleFilterParams,
+	"GET /people/stream":      {"filter[]"},
+	"GET /people/timeline":    {"granularity", "from", "to"},
+	"GET /people/{id}/diff":   {"against"},
+	"POST /people":            {"if_not_exists"},
+	"PUT /people/{id}":        {"on_conflict"},
+	"POST /admin/recompute":   {"field"},
+}
+
+// strictParamsMiddleware rejects requests with query parameters the route
+// doesn't read, so typos like ?pge=2 aren't silently ignored. It applies
+// when config.StrictParams is set or the request asks with ?strict=true.
+func strictParamsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+		if !config.StrictParams && values.Get("strict") != "true" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		path, _ := mux.CurrentRoute(r).GetPathTemplate()
+		known := map[string]bool{}
+		for _, list := range [][]string{commonParams, routeParams[r.Method+" "+path]} {
+			for _, p := range list {
+				known[p] = true
+			}
+		}
+		var unknown []string
+		for p := range values {
+			if !known[p] && !(known["filter[]"] && strings.HasPrefix(p, "filter[")) {
+				unknown = append(unknown, p)
+			}
+		}
+		if len(unknown) > 0 {
+			sort.Strings(unknown)
+			handleClientError(w, http.StatusBadRequest, fmt.Sprintf("unknown query parameters: %s", strings.Join(unknown, ", ")))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestStrictParamsMiddleware(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	router := mux.NewRouter()
+	router.Use(strictParamsMiddleware)
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router.HandleFunc("/people", ok).Methods("GET")
+	router.HandleFunc("/people/top", ok).Methods("GET")
+	router.HandleFunc("/people/{id}", ok).Methods("GET")
+
+	serve := func(target string) (int, string) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		var body errorBody
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body.Error
+	}
+
+	config.StrictParams = false
+	if code, _ := serve("/people?pge=2"); code != http.StatusOK {
+		t.Errorf("unknown parameter outside strict mode: status %d, want 200", code)
+	}
+	if code, msg := serve("/people?pge=2&strict=true"); code != http.StatusBadRequest || msg != "unknown query parameters: pge" {
+		t.Errorf("?strict=true: status %d, %q", code, msg)
+	}
+
+	config.StrictParams = true
+	tests := []struct {
+		target string
+		want   int
+		msg    string
+	}{
+		{"/people?page=2&filter[age][gt]=3&tz=UTC&max_time_ms=100", http.StatusOK, ""},
+		{"/people/top?percent=10&name=Ann&filter[age][gt]=3", http.StatusOK, ""},
+		{"/people/top?percent=10&sort=age", http.StatusBadRequest, "unknown query parameters: sort"},
+		{"/people/abc?zeta=1&alpha=2", http.StatusBadRequest, "unknown query parameters: alpha, zeta"},
+		{"/people/abc?ext_json=true", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		if code, msg := serve(tt.target); code != tt.want || msg != tt.msg {
+			t.Errorf("GET %s: status %d, %q; want %d, %q", tt.target, code, msg, tt.want, tt.msg)
+		}
+	}
+}
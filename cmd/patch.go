@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// personPatch holds the fields a PATCH may change; nil fields are left
+// alone.
+type personPatch struct {
+	Name    *string   `json:"name"`
+	Age     *int      `json:"age"`
+	Address *string   `json:"address"`
+	Email   *string   `json:"email"`
+	Tags    *[]string `json:"tags"`
+}
+
+func (p personPatch) apply(person *Person) {
+	if p.Name != nil {
+		person.Name = *p.Name
+	}
+	if p.Age != nil {
+		person.Age = *p.Age
+	}
+	if p.Address != nil {
+		person.Address = *p.Address
+	}
+	if p.Email != nil {
+		person.Email = *p.Email
+	}
+	if p.Tags != nil {
+		person.Tags = *p.Tags
+	}
+}
+
+// patchError carries a status for failures detected inside the PATCH
+// transaction, which aborts it.
+type patchError struct {
+	status int
+	msg    string
+}
+
+func (e patchError) Error() string {
+	return e.msg
+}
+
+// PatchPerson changes only the fields present in the body. The pre-image
+// is read and the update written in one transaction, so the merged result
+// is validated against the document actually being changed.
+//
+// With "Prefer: return=diff" the response holds only the fields that
+// changed, in the form returned by the diff endpoint; otherwise it is the
+// whole updated person.
+func PatchPerson(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling PATCH request for /people/id")
+	filter, err := personFilter(r.Context(), "id", mux.Vars(r)["id"])
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var patch personPatch
+	if err := decodeObject(r.Body, &patch, true); err != nil {
+		if errors.Is(err, errNotObject) {
+			handleClientError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		handleClientError(w, http.StatusBadRequest, "malformed JSON: "+err.Error())
+		return
+	}
+
+	var before, after Person
+	collection := client.Database(Database).Collection(Collection)
+	err = withTransaction(r.Context(), func(sc mongo.SessionContext) error {
+		before = Person{}
+		readFilter, conditional := unmodifiedSinceFilter(r, filter)
+		if err := collection.FindOne(sc, readFilter).Decode(&before); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) && conditional {
+				if n, cerr := collection.CountDocuments(sc, filter); cerr == nil && n > 0 {
+					return patchError{http.StatusPreconditionFailed, "person was modified since the given date"}
+				}
+			}
+			return err
+		}
+		if failed, err := ifMatchFails(r, before); err != nil || failed {
+			if failed {
+				return patchError{http.StatusPreconditionFailed, errETagMismatch.Error()}
+			}
+			return err
+		}
+
+		after = before
+		patch.apply(&after)
+		if err := sanitizePerson(&after); err != nil {
+			return patchError{http.StatusBadRequest, err.Error()}
+		}
+		normalizePerson(&after)
+		if err := checkTagLimits(after.Tags); err != nil {
+			return patchError{http.StatusBadRequest, err.Error()}
+		}
+		if err := after.Validate(); err != nil {
+			return patchError{http.StatusUnprocessableEntity, err.Error()}
+		}
+		after.UpdatedAt, after.UpdatedBy = now(), actor(r.Context())
+
+		set := after
+		set.ID = primitive.NilObjectID
+		update, err := personUpdate(set)
+		if err != nil {
+			return err
+		}
+		_, err = collection.UpdateOne(sc, bson.M{"_id": before.ID}, update)
+		return err
+	})
+	var perr patchError
+	if errors.As(err, &perr) {
+		handleClientError(w, perr.status, perr.msg)
+		return
+	}
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	setUpdateCounts(w, 1, personChanged(before, after))
+	if preferDiff(r) {
+		setLastModified(w, after)
+		w.Header().Set("Preference-Applied", "return=diff")
+		writeJSON(w, r, http.StatusOK, diffPeople(before, after))
+		return
+	}
+	writePerson(w, r, http.StatusOK, after)
+}
+
+// preferDiff reports whether the client sent "Prefer: return=diff".
+func preferDiff(r *http.Request) bool {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), "return=diff") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestPatchPersonReturnDiff(t *testing.T) {
+	id := primitive.NewObjectID()
+	stored := bson.D{{Key: "_id", Value: id}, {Key: "name", Value: "Ann"}, {Key: "age", Value: int32(5)}}
+
+	runMock(t, "age 5 to 0", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, Database+"."+Collection, mtest.FirstBatch, stored),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: int32(1)}, bson.E{Key: "nModified", Value: int32(1)}),
+			mtest.CreateSuccessResponse(),
+		)
+		r := mux.SetURLVars(httptest.NewRequest("PATCH", "/people/"+id.Hex(), strings.NewReader(`{"age":0}`)), map[string]string{"id": id.Hex()})
+		r.Header.Set("Prefer", "return=diff")
+		rec := httptest.NewRecorder()
+		PatchPerson(rec, r)
+		if rec.Code != http.StatusOK || rec.Header().Get("Preference-Applied") != "return=diff" {
+			mt.Fatalf("status %d, Preference-Applied %q: %s", rec.Code, rec.Header().Get("Preference-Applied"), rec.Body)
+		}
+
+		// The old value can only have come from the document read in the
+		// transaction.
+		var diff personDiff
+		if err := json.Unmarshal(rec.Body.Bytes(), &diff); err != nil {
+			mt.Fatal(err)
+		}
+		if want := (fieldChange{Old: 5.0, New: 0.0}); len(diff.Changed) != 1 || !reflect.DeepEqual(diff.Changed["age"], want) || len(diff.Removed) != 0 {
+			mt.Errorf("diff = %+v, want only age changed from 5 to 0", diff)
+		}
+
+		find, update, commit := mt.GetStartedEvent(), mt.GetStartedEvent(), mt.GetStartedEvent()
+		if find.CommandName != "find" || update.CommandName != "update" || commit.CommandName != "commitTransaction" {
+			mt.Fatalf("commands %s, %s, %s; want find, update, commitTransaction", find.CommandName, update.CommandName, commit.CommandName)
+		}
+		if started, _ := find.Command.Lookup("startTransaction").BooleanOK(); !started {
+			mt.Error("the pre-image was read outside the transaction")
+		}
+		if !reflect.DeepEqual(find.Command.Lookup("txnNumber"), update.Command.Lookup("txnNumber")) {
+			mt.Error("the pre-image and the update were in different transactions")
+		}
+	})
+}
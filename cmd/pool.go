@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// Policies for requests arriving while every pooled connection is in use.
+const (
+	// poolQueue lets them wait for a connection for as long as their
+	// operations' timeouts allow. This is the default.
+	poolQueue = "queue"
+	// poolFailFast answers 503 if no connection frees up within
+	// config.PoolWaitTimeout.
+	poolFailFast = "fail_fast"
+)
+
+// poolTracker counts the connections checked out of the driver's pool for
+// each server. The driver has no wait queue timeout of its own, so
+// fail-fast mode waits here instead.
+type poolTracker struct {
+	mu sync.Mutex
+	// inUse is keyed by server address: config.PoolMaxSize applies to
+	// each server's pool, not to all of them together.
+	inUse map[string]int
+	// freed is closed, and replaced, whenever a connection is returned.
+	freed chan struct{}
+}
+
+var pool = &poolTracker{inUse: map[string]int{}, freed: make(chan struct{})}
+
+// monitor returns the driver pool monitor feeding t.
+func (t *poolTracker) monitor() *event.PoolMonitor {
+	return &event.PoolMonitor{Event: func(e *event.PoolEvent) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		switch e.Type {
+		case event.GetSucceeded:
+			t.inUse[e.Address]++
+		case event.ConnectionReturned:
+			t.inUse[e.Address]--
+			t.signal()
+		case event.PoolClosedEvent:
+			// The server left the topology; its connections go with it.
+			delete(t.inUse, e.Address)
+			t.signal()
+		}
+	}}
+}
+
+// signal wakes the waiters. t.mu must be held.
+func (t *poolTracker) signal() {
+	close(t.freed)
+	t.freed = make(chan struct{})
+}
+
+// available reports whether some server's pool has fewer than max
+// connections in use. A request can't know beforehand which server it
+// will be sent to, so it only waits while every pool is full. t.mu must
+// be held.
+func (t *poolTracker) available(max int) bool {
+	if len(t.inUse) == 0 {
+		return true
+	}
+	for _, n := range t.inUse {
+		if n < max {
+			return true
+		}
+	}
+	return false
+}
+
+// wait returns true once a server's pool has fewer than max connections in
+// use, or false if that doesn't happen within timeout.
+func (t *poolTracker) wait(ctx context.Context, max int, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		t.mu.Lock()
+		if t.available(max) {
+			t.mu.Unlock()
+			return true
+		}
+		freed := t.freed
+		t.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// poolGateMiddleware implements the fail-fast policy: while every server's
+// pool is exhausted, data requests wait up to config.PoolWaitTimeout for a
+// connection to be returned and then get a 503. Requests that don't use
+// MongoDB pass straight through. A connection can still be taken by
+// another request between the check and the handler's first operation,
+// so this bounds the wait rather than guaranteeing a connection.
+func poolGateMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !startupPaths[r.URL.Path] && !strings.HasPrefix(r.URL.Path, "/health/") &&
+			!pool.wait(r.Context(), config.PoolMaxSize, config.PoolWaitTimeout) {
+			writeError(w, http.StatusServiceUnavailable, "no database connection available")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
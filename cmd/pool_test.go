@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+func TestPoolTrackerPerServer(t *testing.T) {
+	tracker := &poolTracker{inUse: map[string]int{}, freed: make(chan struct{})}
+	monitor := tracker.monitor()
+	send := func(typ, address string) {
+		monitor.Event(&event.PoolEvent{Type: typ, Address: address})
+	}
+	ctx := context.Background()
+
+	if !tracker.wait(ctx, 2, 0) {
+		t.Error("no room before any connection was used")
+	}
+	send(event.GetSucceeded, "a:27017")
+	send(event.GetSucceeded, "a:27017")
+	send(event.GetSucceeded, "b:27017")
+	if !tracker.wait(ctx, 2, 0) {
+		t.Error("no room while b's pool has a free connection")
+	}
+	send(event.GetSucceeded, "b:27017")
+	if tracker.wait(ctx, 2, time.Millisecond) {
+		t.Error("room while both pools are full")
+	}
+
+	// A waiter gets through once a connection is returned to either.
+	got := make(chan bool)
+	go func() { got <- tracker.wait(ctx, 2, time.Minute) }()
+	time.Sleep(10 * time.Millisecond)
+	send(event.ConnectionReturned, "b:27017")
+	if !<-got {
+		t.Error("waiter not released by a returned connection")
+	}
+
+	// A server that leaves the topology takes its count with it.
+	send(event.GetSucceeded, "b:27017")
+	send(event.PoolClosedEvent, "a:27017")
+	send(event.PoolClosedEvent, "b:27017")
+	if !tracker.wait(ctx, 2, 0) {
+		t.Error("no room after every pool was closed")
+	}
+}
+
+func TestPoolTrackerWaitCanceled(t *testing.T) {
+	tracker := &poolTracker{inUse: map[string]int{"a:27017": 1}, freed: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if tracker.wait(ctx, 1, time.Minute) {
+		t.Error("wait succeeded for a canceled request")
+	}
+}
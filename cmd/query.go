@@ -0,0 +1,535 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PeopleQuery holds the filter, sort and pagination options shared by
+// GET /people (query string) and POST /people/search (JSON body).
+type PeopleQuery struct {
+	IDs     []string `json:"ids"`
+	Name    string   `json:"name"`
+	Address string   `json:"address"`
+	MinAge  *int     `json:"min_age"`
+	MaxAge  *int     `json:"max_age"`
+
+	// MinAgeExclusive and MaxAgeExclusive make the matching bound strict
+	// ($gt/$lt) instead of inclusive ($gte/$lte). Each needs its bound.
+	MinAgeExclusive bool `json:"min_age_exclusive"`
+	MaxAgeExclusive bool `json:"max_age_exclusive"`
+
+	// Tags selects people that have all of the listed tags.
+	Tags []string `json:"tags"`
+
+	// Missing and Has select people without or with the listed fields.
+	Missing []string `json:"missing"`
+	Has     []string `json:"has"`
+
+	// Where is a query in the filter DSL, see compileWhere.
+	Where json.RawMessage `json:"where"`
+
+	Sort string `json:"sort"`
+	Page int    `json:"page"`
+
+	// PageSize is nil when the client didn't ask for a page size. An
+	// explicit 0 follows config.ZeroPageSize.
+	PageSize *int `json:"page_size"`
+
+	// After is the next_cursor of a previous page. It pages by key instead
+	// of by offset, so it can't be combined with Page and must be used with
+	// the same sort.
+	After string `json:"after"`
+
+	// Estimate allows an unfiltered total to come from collection metadata
+	// via EstimatedDocumentCount instead of a full count. The estimate can
+	// be off after unclean shutdowns or on sharded clusters with orphaned
+	// documents; filtered totals are always counted exactly.
+	Estimate bool `json:"estimate"`
+}
+
+// filterFields lists the fields that may be used in filters.
+var filterFields = map[string]bool{
+	"name":    true,
+	"age":     true,
+	"address": true,
+	"email":   true,
+	"tags":    true,
+}
+
+// sortFields maps the field names accepted in sort to document fields.
+var sortFields = map[string]string{
+	"id":      "_id",
+	"name":    "name",
+	"age":     "age",
+	"address": "address",
+}
+
+// parsePeopleQuery reads a PeopleQuery from URL query parameters. List
+// parameters accept both repeated and comma separated values.
+func parsePeopleQuery(values url.Values) (PeopleQuery, error) {
+	q := PeopleQuery{
+		IDs:     splitParams(values["ids"]),
+		Name:    values.Get("name"),
+		Address: values.Get("address"),
+		Tags:    splitParams(values["tags"]),
+		Missing: splitParams(values["missing"]),
+		Has:     splitParams(values["has"]),
+		Sort:    values.Get("sort"),
+		After:   values.Get("after"),
+	}
+	if where := values.Get("where"); where != "" {
+		q.Where = json.RawMessage(where)
+	}
+	params, err := filterParams(values)
+	if err != nil {
+		return q, err
+	}
+	if params != nil && q.Where != nil {
+		q.Where = json.RawMessage(`{"$and":[` + string(q.Where) + `,` + string(params) + `]}`)
+	} else if params != nil {
+		q.Where = params
+	}
+	if q.MinAge, err = optionalIntParam(values, "min_age"); err != nil {
+		return q, err
+	}
+	if q.MaxAge, err = optionalIntParam(values, "max_age"); err != nil {
+		return q, err
+	}
+	if q.MinAgeExclusive, err = boolParam(values, "min_age_exclusive"); err != nil {
+		return q, err
+	}
+	if q.MaxAgeExclusive, err = boolParam(values, "max_age_exclusive"); err != nil {
+		return q, err
+	}
+	if q.Page, err = intParam(values, "page"); err != nil {
+		return q, err
+	}
+	if q.PageSize, err = optionalIntParam(values, "page_size"); err != nil {
+		return q, err
+	}
+	if q.Estimate, err = boolParam(values, "estimate"); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+func splitParams(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+func intParam(values url.Values, key string) (int, error) {
+	n, err := optionalIntParam(values, key)
+	if err != nil || n == nil {
+		return 0, err
+	}
+	return *n, nil
+}
+
+func optionalIntParam(values url.Values, key string) (*int, error) {
+	v := values.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an integer", key)
+	}
+	return &n, nil
+}
+
+func boolParam(values url.Values, key string) (bool, error) {
+	v := values.Get(key)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", key)
+	}
+	return b, nil
+}
+
+// filter builds the MongoDB filter for q. Filtering on a field the caller
+// can't see is rejected, since the matches would give its values away.
+func (q PeopleQuery) filter(ctx context.Context) (bson.M, error) {
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"name", q.Name != ""},
+		{"address", q.Address != ""},
+		{"tags", len(q.Tags) > 0},
+		{"age", q.MinAge != nil || q.MaxAge != nil},
+	} {
+		if f.set && !fieldVisible(ctx, f.name) {
+			return nil, fmt.Errorf("field %q is not available", f.name)
+		}
+	}
+
+	filter := bson.M{}
+	if len(q.IDs) > 0 {
+		ids := make([]primitive.ObjectID, len(q.IDs))
+		for i, id := range q.IDs {
+			objectID, err := parseObjectID(fmt.Sprintf("ids[%d]", i), id)
+			if err != nil {
+				return nil, err
+			}
+			ids[i] = objectID
+		}
+		filter["_id"] = bson.M{"$in": ids}
+	}
+	if q.Name != "" {
+		filter["name"] = q.Name
+	}
+	if q.Address != "" {
+		filter["address"] = q.Address
+	}
+	if len(q.Tags) > 0 {
+		filter["tags"] = bson.M{"$all": q.Tags}
+	}
+	age, err := q.ageFilter()
+	if err != nil {
+		return nil, err
+	}
+	if len(age) > 0 {
+		filter["age"] = age
+	}
+	if err := addExistsFilters(ctx, filter, q.Missing, q.Has); err != nil {
+		return nil, err
+	}
+	if len(q.Where) > 0 && string(q.Where) != "null" {
+		where, err := compileWhere(ctx, q.Where)
+		if err != nil {
+			return nil, err
+		}
+		if len(where) > 0 {
+			and, _ := filter["$and"].([]bson.M)
+			filter["$and"] = append(and, where)
+		}
+	}
+	return filter, nil
+}
+
+// ageFilter builds the age range condition. Bounds are inclusive unless
+// their exclusive flag is set; a range that can't match anything, such as
+// min_age=30&min_age_exclusive=true&max_age=30, is rejected.
+func (q PeopleQuery) ageFilter() (bson.M, error) {
+	if q.MinAgeExclusive && q.MinAge == nil {
+		return nil, fmt.Errorf("min_age_exclusive requires min_age")
+	}
+	if q.MaxAgeExclusive && q.MaxAge == nil {
+		return nil, fmt.Errorf("max_age_exclusive requires max_age")
+	}
+	if q.MinAge != nil && q.MaxAge != nil {
+		lo, hi := *q.MinAge, *q.MaxAge
+		if lo > hi || (lo == hi && (q.MinAgeExclusive || q.MaxAgeExclusive)) {
+			return nil, fmt.Errorf("age range from min_age to max_age is empty")
+		}
+	}
+	age := bson.M{}
+	if q.MinAge != nil {
+		op := "$gte"
+		if q.MinAgeExclusive {
+			op = "$gt"
+		}
+		age[op] = *q.MinAge
+	}
+	if q.MaxAge != nil {
+		op := "$lte"
+		if q.MaxAgeExclusive {
+			op = "$lt"
+		}
+		age[op] = *q.MaxAge
+	}
+	return age, nil
+}
+
+// addExistsFilters adds a $exists condition for each missing and has field.
+// Conditions are combined with $and so they don't clobber other filters on
+// the same field.
+func addExistsFilters(ctx context.Context, filter bson.M, missing, has []string) error {
+	var conds []bson.M
+	seen := map[string]string{}
+	for _, list := range []struct {
+		param  string
+		fields []string
+		exists bool
+	}{{"missing", missing, false}, {"has", has, true}} {
+		for _, field := range list.fields {
+			if !filterFields[field] {
+				return fmt.Errorf("%s: unknown field %q", list.param, field)
+			}
+			if !fieldVisible(ctx, field) {
+				return fmt.Errorf("%s: field %q is not available", list.param, field)
+			}
+			if other, ok := seen[field]; ok && other != list.param {
+				return fmt.Errorf("field %q cannot be both missing and present", field)
+			}
+			seen[field] = list.param
+			conds = append(conds, bson.M{field: bson.M{"$exists": list.exists}})
+		}
+	}
+	if len(conds) > 0 {
+		filter["$and"] = conds
+	}
+	return nil
+}
+
+// sortSpec parses a sort string such as "age,-name". A leading "-" sorts
+// descending. _id is always appended as a tiebreaker so pages are stable.
+// Every invalid entry is reported, with its 1-based position in the list.
+// Fields the caller can't see can't be sorted on either.
+func (q PeopleQuery) sortSpec(ctx context.Context) (bson.D, error) {
+	if strings.TrimSpace(q.Sort) == "" {
+		return bson.D{{Key: "_id", Value: 1}}, nil
+	}
+
+	var spec bson.D
+	var problems []string
+	seen := map[string]bool{}
+	for i, part := range strings.Split(q.Sort, ",") {
+		part = strings.TrimSpace(part)
+		name := strings.TrimPrefix(part, "-")
+		dir := 1
+		if name != part {
+			dir = -1
+		}
+		field, ok := sortFields[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%q at position %d is not a sortable field", part, i+1))
+		case !fieldVisible(ctx, name):
+			problems = append(problems, fmt.Sprintf("%q at position %d is not available", part, i+1))
+		case seen[field]:
+			problems = append(problems, fmt.Sprintf("%q at position %d is repeated", part, i+1))
+		default:
+			seen[field] = true
+			spec = append(spec, bson.E{Key: field, Value: dir})
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid sort: %s", strings.Join(problems, "; "))
+	}
+	if !seen["_id"] {
+		spec = append(spec, bson.E{Key: "_id", Value: 1})
+	}
+	return spec, nil
+}
+
+// pagination returns the page number and size to use, applying defaults.
+// The size is never 0, which MongoDB would take as no limit at all.
+func (q PeopleQuery) pagination() (page, size int, err error) {
+	page, size = q.Page, config.DefaultPageSize
+	if page == 0 {
+		page = 1
+	}
+	if q.PageSize != nil && (*q.PageSize != 0 || config.ZeroPageSize == zeroPageSizeReject) {
+		size = *q.PageSize
+	}
+	if page < 1 {
+		return 0, 0, fmt.Errorf("page must be at least 1")
+	}
+	if size < 1 || size > config.MaxPageSize {
+		return 0, 0, fmt.Errorf("page_size must be between 1 and %d", config.MaxPageSize)
+	}
+	return page, size, nil
+}
+
+// afterFilter returns the keyset filter for q.After, or nil when q pages
+// by offset.
+func (q PeopleQuery) afterFilter(sort bson.D) (bson.M, error) {
+	if q.After == "" {
+		return nil, nil
+	}
+	if q.Page != 0 {
+		return nil, fmt.Errorf("after cannot be combined with page")
+	}
+	return decodeCursor(q.After, sort)
+}
+
+// Policies for an explicit page_size=0.
+const (
+	// zeroPageSizeDefault uses config.DefaultPageSize, as if page_size had
+	// been left out. This is the default.
+	zeroPageSizeDefault = "default"
+	// zeroPageSizeReject answers 400.
+	zeroPageSizeReject = "reject"
+)
+
+// peoplePage is one page of a people listing.
+type peoplePage struct {
+	Items    []Person `json:"items" bson:"items"`
+	Total    int64    `json:"total" bson:"total"`
+	PageSize int      `json:"page_size" bson:"page_size"`
+
+	// Page is the page number, or 0 when the page was selected with after.
+	Page int `json:"page,omitempty" bson:"page,omitempty"`
+
+	// NextCursor is the after cursor for the following page. It is null
+	// once a page comes back short, so paging loops end cleanly.
+	NextCursor *string `json:"next_cursor" bson:"next_cursor"`
+
+	// Truncated is set when reading the page failed partway and
+	// config.PartialResults returned what had been read. NextCursor then
+	// continues right after the last item.
+	Truncated bool `json:"truncated,omitempty" bson:"truncated,omitempty"`
+
+	// Estimated is set when Total came from EstimatedDocumentCount.
+	Estimated bool `json:"estimated,omitempty" bson:"estimated,omitempty"`
+}
+
+// queryError is returned by listPeople when the query itself is invalid.
+type queryError struct {
+	msg string
+}
+
+func (e queryError) Error() string {
+	return e.msg
+}
+
+func listPeople(ctx context.Context, q PeopleQuery) (peoplePage, error) {
+	filter, err := q.filter(ctx)
+	if err != nil {
+		return peoplePage{}, queryError{err.Error()}
+	}
+	sort, err := q.sortSpec(ctx)
+	if err != nil {
+		return peoplePage{}, queryError{err.Error()}
+	}
+	page, size, err := q.pagination()
+	if err != nil {
+		return peoplePage{}, queryError{err.Error()}
+	}
+	after, err := q.afterFilter(sort)
+	if err != nil {
+		return peoplePage{}, queryError{err.Error()}
+	}
+	return findPeoplePage(ctx, filter, after, sort, page, size, q.Estimate)
+}
+
+// findPeoplePage runs a paginated find along with the count of all
+// matching documents. With a keyset filter from afterFilter the page starts
+// after the cursor instead of at an offset; the total still counts every
+// document matching filter.
+func findPeoplePage(ctx context.Context, filter, after bson.M, sort bson.D, page, size int, estimate bool) (peoplePage, error) {
+	if size < 1 {
+		// SetLimit(0) would return the whole collection.
+		return peoplePage{}, fmt.Errorf("page size %d is not positive", size)
+	}
+	collection := client.Database(Database).Collection(Collection)
+	opts := findOptions(ctx).
+		SetSort(sort).
+		SetLimit(int64(size))
+	// Soft-deleted people are also excluded from the total, so it can't
+	// come from the collection's metadata.
+	unfiltered := len(filter) == 0 && !config.SoftDelete
+	filter = liveFilter(filter)
+	find := filter
+	if after != nil {
+		page = 0
+		find = bson.M{"$and": []bson.M{filter, after}}
+	} else {
+		opts.SetSkip(int64((page - 1) * size))
+	}
+	if projection := projectionFor(ctx); projection != nil {
+		// The cursor needs the sort keys even if the caller can't see them;
+		// writeJSON strips them from the response.
+		for _, e := range sort {
+			projection[e.Key] = 1
+		}
+		opts.SetProjection(projection)
+	}
+	cur, err := collection.Find(ctx, find, opts)
+	if err != nil {
+		return peoplePage{}, err
+	}
+	defer cur.Close(ctx)
+
+	people := []Person{}
+	var last bson.Raw
+	var iterErr error
+	for cur.Next(ctx) {
+		var person Person
+		if iterErr = cur.Decode(&person); iterErr != nil {
+			break
+		}
+		people = append(people, person)
+		last = append(last[:0], cur.Current...)
+	}
+	if iterErr == nil {
+		iterErr = cur.Err()
+	}
+
+	result := peoplePage{Items: people, Page: page, PageSize: size}
+	if iterErr != nil {
+		if !config.PartialResults || len(people) == 0 {
+			return peoplePage{}, iterErr
+		}
+		log.Printf("Returning %d people after a cursor error: %v", len(people), iterErr)
+		result.Truncated = true
+	}
+	if len(people) == size || result.Truncated {
+		next, err := encodeCursor(sort, last)
+		if err != nil {
+			return peoplePage{}, err
+		}
+		result.NextCursor = &next
+	}
+	if estimate && unfiltered {
+		opts := options.EstimatedDocumentCount()
+		if d := queryMaxTime(ctx); d > 0 {
+			opts.SetMaxTime(d)
+		}
+		result.Total, err = collection.EstimatedDocumentCount(ctx, opts)
+		result.Estimated = true
+	} else {
+		result.Total, err = collection.CountDocuments(ctx, filter, countOptions(ctx))
+	}
+	if err != nil {
+		return peoplePage{}, err
+	}
+	return result, nil
+}
+
+// setPageHeaders reports the listing totals in response headers.
+func setPageHeaders(w http.ResponseWriter, page peoplePage) {
+	w.Header().Set("X-Total-Count", strconv.FormatInt(page.Total, 10))
+	if page.Estimated {
+		w.Header().Set("X-Total-Count-Estimated", "true")
+	}
+	if page.NextCursor != nil {
+		w.Header().Set("X-Next-Cursor", *page.NextCursor)
+	}
+	if page.Truncated {
+		w.Header().Set("X-Result-Truncated", "true")
+		w.Header().Set("Warning", `199 - "results truncated by a database error"`)
+	}
+}
+
+// handleListError responds to an error returned by listPeople.
+func handleListError(w http.ResponseWriter, err error) {
+	if qerr, ok := err.(queryError); ok {
+		handleClientError(w, http.StatusBadRequest, qerr.msg)
+		return
+	}
+	handleError(w, err)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// peopleQuery parses a GET /people query string.
+func peopleQuery(t *testing.T, query string) PeopleQuery {
+	t.Helper()
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := parsePeopleQuery(values)
+	if err != nil {
+		t.Fatalf("parsePeopleQuery(%s): %v", query, err)
+	}
+	return q
+}
+
+func TestExistsFilters(t *testing.T) {
+	got, err := peopleQuery(t, "missing=email&has=address,tags").filter(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{"$and": []bson.M{
+		{"email": bson.M{"$exists": false}},
+		{"address": bson.M{"$exists": true}},
+		{"tags": bson.M{"$exists": true}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("filter = %v, want %v", got, want)
+	}
+
+	for query, msg := range map[string]string{
+		"missing=salary":          `missing: unknown field "salary"`,
+		"missing=email&has=email": `field "email" cannot be both missing and present`,
+	} {
+		if _, err := peopleQuery(t, query).filter(context.Background()); err == nil || err.Error() != msg {
+			t.Errorf("filter(%s) error = %v, want %q", query, err, msg)
+		}
+	}
+}
+
+func TestExistsFiltersWithWhere(t *testing.T) {
+	// Both go under $and, so neither replaces the other.
+	got, err := peopleQuery(t, `has=email&where={"age":{"$gt":1}}`).filter(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{"$and": []bson.M{
+		{"email": bson.M{"$exists": true}},
+		{"age": bson.M{"$gt": int64(1)}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("filter = %v, want %v", got, want)
+	}
+}
+
+func TestSortSpec(t *testing.T) {
+	tests := []struct {
+		sort string
+		want bson.D
+	}{
+		{"", bson.D{{Key: "_id", Value: 1}}},
+		{"age,-name", bson.D{{Key: "age", Value: 1}, {Key: "name", Value: -1}, {Key: "_id", Value: 1}}},
+		{" -age , id ", bson.D{{Key: "age", Value: -1}, {Key: "_id", Value: 1}}},
+		{"-id", bson.D{{Key: "_id", Value: -1}}},
+	}
+	for _, tt := range tests {
+		got, err := PeopleQuery{Sort: tt.sort}.sortSpec(context.Background())
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sortSpec(%q) = %v, %v; want %v", tt.sort, got, err, tt.want)
+		}
+	}
+}
+
+func TestSortSpecRejects(t *testing.T) {
+	_, err := PeopleQuery{Sort: "age,salary,-age,"}.sortSpec(context.Background())
+	want := `invalid sort: "salary" at position 2 is not a sortable field; "-age" at position 3 is repeated; "" at position 4 is not a sortable field`
+	if err == nil || err.Error() != want {
+		t.Errorf("sortSpec error = %v, want %q", err, want)
+	}
+}
+
+func TestAgeFilter(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bson.M
+	}{
+		{"", bson.M{}},
+		{"min_age=20&max_age=30", bson.M{"$gte": 20, "$lte": 30}},
+		{"min_age=20&min_age_exclusive=true&max_age=30&max_age_exclusive=true", bson.M{"$gt": 20, "$lt": 30}},
+		{"max_age=30&max_age_exclusive=true", bson.M{"$lt": 30}},
+		{"min_age=30&max_age=30", bson.M{"$gte": 30, "$lte": 30}},
+	}
+	for _, tt := range tests {
+		got, err := peopleQuery(t, tt.query).ageFilter()
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ageFilter(%s) = %v, %v; want %v", tt.query, got, err, tt.want)
+		}
+	}
+}
+
+func TestAgeFilterRejects(t *testing.T) {
+	tests := []struct {
+		query, err string
+	}{
+		{"min_age_exclusive=true", "min_age_exclusive requires min_age"},
+		{"max_age_exclusive=true&min_age=3", "max_age_exclusive requires max_age"},
+		{"min_age=31&max_age=30", "age range from min_age to max_age is empty"},
+		{"min_age=30&min_age_exclusive=true&max_age=30", "age range from min_age to max_age is empty"},
+	}
+	for _, tt := range tests {
+		if _, err := peopleQuery(t, tt.query).ageFilter(); err == nil || err.Error() != tt.err {
+			t.Errorf("ageFilter(%s) error = %v, want %q", tt.query, err, tt.err)
+		}
+	}
+}
+
+func TestPagination(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.DefaultPageSize, config.MaxPageSize = 20, 100
+
+	for _, mode := range []string{zeroPageSizeDefault, zeroPageSizeReject} {
+		config.ZeroPageSize = mode
+		tests := []struct {
+			query      string
+			page, size int
+			err        bool
+		}{
+			{"", 1, 20, false},
+			{"page=3&page_size=50", 3, 50, false},
+			{"page_size=100", 1, 100, false},
+			{"page_size=0", 1, 20, mode == zeroPageSizeReject},
+			{"page_size=101", 0, 0, true},
+			{"page_size=-1", 0, 0, true},
+			{"page=-1", 0, 0, true},
+		}
+		for _, tt := range tests {
+			page, size, err := peopleQuery(t, tt.query).pagination()
+			if tt.err {
+				if err == nil {
+					t.Errorf("%s: pagination(%s) = %d, %d; want an error", mode, tt.query, page, size)
+				}
+				continue
+			}
+			if err != nil || page != tt.page || size != tt.size {
+				t.Errorf("%s: pagination(%s) = %d, %d, %v; want %d, %d", mode, tt.query, page, size, err, tt.page, tt.size)
+			}
+		}
+	}
+}
+
+func TestAfterFilter(t *testing.T) {
+	sort := bson.D{{Key: "_id", Value: 1}}
+	if f, err := (PeopleQuery{}).afterFilter(sort); f != nil || err != nil {
+		t.Errorf("afterFilter without after = %v, %v; want nil, nil", f, err)
+	}
+	if _, err := (PeopleQuery{After: "x", Page: 2}).afterFilter(sort); err == nil || err.Error() != "after cannot be combined with page" {
+		t.Errorf("afterFilter with page: error = %v", err)
+	}
+}
+
+func TestListPeopleEstimate(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.SoftDelete = false
+
+	tests := []struct {
+		query, command string
+		estimated      bool
+	}{
+		{"estimate=true", "count", true},
+		// A filter needs an exact count.
+		{"estimate=true&name=Ann", "aggregate", false},
+		{"", "aggregate", false},
+	}
+	for _, tt := range tests {
+		runMock(t, tt.query, func(mt *mtest.T) {
+			count := countResponse(7)
+			if tt.command == "count" {
+				count = mtest.CreateSuccessResponse(bson.E{Key: "n", Value: int32(7)})
+			}
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, Database+"."+Collection, mtest.FirstBatch), count)
+			page, err := listPeople(context.Background(), peopleQuery(t, tt.query))
+			if err != nil {
+				mt.Fatal(err)
+			}
+			counted := mt.GetAllStartedEvents()[1].CommandName
+			if counted != tt.command || page.Estimated != tt.estimated || page.Total != 7 {
+				mt.Errorf("counted with %s, total %d, estimated %v; want %s, 7, %v",
+					counted, page.Total, page.Estimated, tt.command, tt.estimated)
+			}
+		})
+	}
+}
+
+func TestListPeopleAllowDiskUse(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	for _, allow := range []bool{true, false} {
+		config.AllowDiskUse = allow
+		runMock(t, fmt.Sprint(allow), func(mt *mtest.T) {
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, Database+"."+Collection, mtest.FirstBatch), countResponse(0))
+			if _, err := listPeople(context.Background(), PeopleQuery{}); err != nil {
+				mt.Fatal(err)
+			}
+			find := mt.GetStartedEvent()
+			if got, ok := find.Command.Lookup("allowDiskUse").BooleanOK(); !ok || got != allow {
+				mt.Errorf("find command %s, want allowDiskUse %v", find.Command, allow)
+			}
+		})
+	}
+}
+
+func TestListPeoplePartialResults(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	ns := Database + "." + Collection
+	batch := []bson.D{
+		{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "name", Value: "Ann"}, {Key: "age", Value: 30}},
+		{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "name", Value: "Bob"}, {Key: "age", Value: 40}},
+	}
+	cursorError := mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 6, Name: "HostUnreachable", Message: "lost the connection"})
+
+	for _, partial := range []bool{true, false} {
+		config.PartialResults = partial
+		runMock(t, fmt.Sprint(partial), func(mt *mtest.T) {
+			// The first batch arrives, then fetching the next one fails.
+			mt.AddMockResponses(mtest.CreateCursorResponse(42, ns, mtest.FirstBatch, batch...), cursorError, countResponse(5))
+			page, err := listPeople(context.Background(), PeopleQuery{})
+			if !partial {
+				if err == nil {
+					mt.Errorf("listPeople = %+v, want the cursor error", page)
+				}
+				return
+			}
+			if err != nil {
+				mt.Fatal(err)
+			}
+			if len(page.Items) != 2 || !page.Truncated || page.NextCursor == nil || page.Total != 5 {
+				mt.Errorf("listPeople = %+v, want the 2 people read, truncated, with a cursor", page)
+			}
+		})
+	}
+}
+
+func TestHiddenFieldsRejected(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.RoleFields = map[string][]string{"reader": {"name", "tags"}}
+	config.NaturalKey = "email"
+	reader := context.WithValue(context.Background(), principalKey{}, Principal{ID: "bob", Role: "reader"})
+
+	for _, query := range []string{"name=Ann", "tags=vip", "has=tags", `where={"name":"Ann"}`, "filter[tags][in]=a,b", "sort=-name"} {
+		q := peopleQuery(t, query)
+		if _, err := q.filter(reader); err != nil {
+			t.Errorf("filter(%s) for a reader: %v", query, err)
+		}
+		if _, err := q.sortSpec(reader); err != nil {
+			t.Errorf("sortSpec(%s) for a reader: %v", query, err)
+		}
+	}
+
+	for _, tt := range []struct{ query, msg string }{
+		{"address=Paris", `field "address" is not available`},
+		{"min_age=18", `field "age" is not available`},
+		{"missing=email", `missing: field "email" is not available`},
+		{`where={"$or":[{"name":"Ann"},{"age":{"$gt":1}}]}`, `where: field "age" is not available`},
+		{"filter[email][exists]=true", `where: field "email" is not available`},
+	} {
+		if _, err := peopleQuery(t, tt.query).filter(reader); err == nil || err.Error() != tt.msg {
+			t.Errorf("filter(%s) for a reader: error = %v, want %q", tt.query, err, tt.msg)
+		}
+		// Without a role every field can be filtered on.
+		if _, err := peopleQuery(t, tt.query).filter(context.Background()); err != nil {
+			t.Errorf("filter(%s) without a role: %v", tt.query, err)
+		}
+	}
+
+	if _, err := peopleQuery(t, "sort=name,-age").sortSpec(reader); err == nil || err.Error() != `invalid sort: "-age" at position 2 is not available` {
+		t.Errorf("sortSpec by a hidden field: error = %v", err)
+	}
+
+	// A hidden natural key can't be used to look people up either.
+	if _, err := personFilter(reader, "id", "ann@example.com"); err == nil || err.Error() != "id must be a 24-character hex string" {
+		t.Errorf("personFilter by a hidden email: error = %v", err)
+	}
+	if _, err := personFilter(context.Background(), "id", "ann@example.com"); err != nil {
+		t.Errorf("personFilter by email without a role: %v", err)
+	}
+}
+
+func TestSearchPeopleMatchesGetPeople(t *testing.T) {
+	ns := Database + "." + Collection
+	person := bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "name", Value: "Ann"}}
+
+	// Far more ids than fit in a URL.
+	ids := make([]string, 2000)
+	for i := range ids {
+		ids[i] = primitive.NewObjectID().Hex()
+	}
+	body, err := json.Marshal(map[string]interface{}{"ids": ids, "page_size": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runMock(t, "large body", func(mt *mtest.T) {
+		serve := func(handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, person), countResponse(3))
+			rec := httptest.NewRecorder()
+			handler(rec, r)
+			return rec
+		}
+		search := serve(SearchPeople, httptest.NewRequest(http.MethodPost, "/people/search", bytes.NewReader(body)))
+		if in, _ := mt.GetStartedEvent().Command.Lookup("filter", "_id", "$in").Array().Values(); len(in) != len(ids) {
+			mt.Errorf("search filtered on %d ids, want %d", len(in), len(ids))
+		}
+		get := serve(GetPeople, httptest.NewRequest(http.MethodGet, "/people?page_size=1", nil))
+
+		for name, rec := range map[string]*httptest.ResponseRecorder{"search": search, "list": get} {
+			var people []Person
+			if err := json.Unmarshal(rec.Body.Bytes(), &people); rec.Code != http.StatusOK || err != nil || len(people) != 1 {
+				mt.Errorf("%s: status %d, body %s; want an array of one person", name, rec.Code, rec.Body)
+			}
+			if rec.Header().Get("X-Total-Count") != "3" || rec.Header().Get("X-Next-Cursor") == "" {
+				mt.Errorf("%s: headers %v, want the total and next cursor", name, rec.Header())
+			}
+		}
+	})
+}
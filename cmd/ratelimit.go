@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slowStartFloor is the fraction of the full rate a slow start begins at.
+const slowStartFloor = 0.1
+
+// rateLimiter is a token bucket shared by every client of this instance.
+// It protects MongoDB rather than apportioning capacity between clients.
+//
+// With a slow start, both the refill rate and the bucket size ramp up
+// linearly from slowStartFloor of their configured values, over the ramp
+// period after startRamp is called.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	ramp   time.Duration
+	start  time.Time
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate, burst int, ramp time.Duration, now time.Time) *rateLimiter {
+	l := &rateLimiter{rate: float64(rate), burst: float64(burst), ramp: ramp}
+	l.startRamp(now)
+	return l
+}
+
+// startRamp restarts the slow start from now, with the bucket at its
+// initial size.
+func (l *rateLimiter) startRamp(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.start, l.last = now, now
+	_, l.tokens = l.limits(now)
+}
+
+// limits returns the rate and bucket size in effect at now.
+func (l *rateLimiter) limits(now time.Time) (rate, burst float64) {
+	f := 1.0
+	if l.ramp > 0 {
+		f = math.Max(slowStartFloor, math.Min(1, float64(now.Sub(l.start))/float64(l.ramp)))
+	}
+	return l.rate * f, math.Max(1, l.burst*f)
+}
+
+// allow takes a token if one is available. Otherwise it returns how long
+// until one will be.
+func (l *rateLimiter) allow(now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rate, burst := l.limits(now)
+	l.tokens = math.Min(burst, l.tokens+now.Sub(l.last).Seconds()*rate)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / rate * float64(time.Second))
+}
+
+// limiter is set when config.RateLimit is.
+var limiter *rateLimiter
+
+// rateLimitMiddleware answers 429 once the instance is over its rate
+// limit, with Retry-After set to when the next request would get through.
+// Health probes aren't limited.
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/health/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := limiter.allow(time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	l := newRateLimiter(10, 5, 0, t0)
+
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.allow(t0); !ok {
+			t.Fatalf("request %d of the burst was refused", i+1)
+		}
+	}
+	ok, wait := l.allow(t0)
+	if ok {
+		t.Fatal("request past the burst was allowed")
+	}
+	if wait != 100*time.Millisecond {
+		t.Errorf("wait = %v, want 100ms at 10 requests per second", wait)
+	}
+	if ok, _ := l.allow(t0.Add(wait)); !ok {
+		t.Error("refused after waiting as told")
+	}
+}
+
+func TestRateLimiterRamp(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	l := newRateLimiter(100, 50, 10*time.Second, t0)
+
+	tests := []struct {
+		after       time.Duration
+		rate, burst float64
+	}{
+		{0, 10, 5},
+		{500 * time.Millisecond, 10, 5},
+		{5 * time.Second, 50, 25},
+		{10 * time.Second, 100, 50},
+		{time.Hour, 100, 50},
+	}
+	for _, tt := range tests {
+		rate, burst := l.limits(t0.Add(tt.after))
+		if math.Abs(rate-tt.rate) > 1e-9 || math.Abs(burst-tt.burst) > 1e-9 {
+			t.Errorf("limits after %v = %v, %v; want %v, %v", tt.after, rate, burst, tt.rate, tt.burst)
+		}
+	}
+
+	// The bucket starts at the initial size, not the full one.
+	allowed := 0
+	for allowed < 100 {
+		if ok, _ := l.allow(t0); !ok {
+			break
+		}
+		allowed++
+	}
+	if allowed != 5 {
+		t.Errorf("%d requests allowed at the start of the ramp, want 5", allowed)
+	}
+
+	// startRamp starts over, as after a restart of the service.
+	later := t0.Add(time.Hour)
+	l.startRamp(later)
+	if rate, burst := l.limits(later); math.Abs(rate-10) > 1e-9 || math.Abs(burst-5) > 1e-9 {
+		t.Errorf("limits after startRamp = %v, %v; want 10, 5", rate, burst)
+	}
+}
+
+func TestRateLimiterSmallBurst(t *testing.T) {
+	// The floor would give a bucket of 0.2 tokens; it is kept at one so
+	// requests can get through at all.
+	t0 := time.Unix(1700000000, 0)
+	l := newRateLimiter(20, 2, time.Minute, t0)
+	if _, burst := l.limits(t0); burst != 1 {
+		t.Errorf("burst = %v, want 1", burst)
+	}
+	if ok, _ := l.allow(t0); !ok {
+		t.Error("first request refused")
+	}
+}
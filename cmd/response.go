@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// writeJSON writes v as the JSON response body with the given status.
+//
+// By default ids are plain hex strings. With ?ext_json=true the body is
+// MongoDB relaxed Extended JSON instead, using bson field names ("_id")
+// and {"$oid": ...} / {"$date": ...} wrappers, so it can be round-tripped
+// through mongoimport and similar tools.
+//
+// People in v are limited to the fields the caller's role may see.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	ext := r.URL.Query().Get("ext_json") == "true"
+	v, err := restrictFields(r.Context(), inZone(r.Context(), v), ext)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ext {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+
+	body, err := marshalExtJSON(v)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+	w.Write([]byte("\n"))
+}
+
+// marshalExtJSON encodes documents, and slices of documents, as relaxed
+// Extended JSON. The driver only encodes documents, so slices are built up
+// element by element.
+func marshalExtJSON(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return bson.MarshalExtJSON(v, false, false)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i := 0; i < rv.Len(); i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		doc, err := bson.MarshalExtJSON(rv.Index(i).Interface(), false, false)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(doc)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// errorBody is the JSON body of every error response.
+type errorBody struct {
+	Error string     `json:"error"`
+	Retry retryGuide `json:"retry"`
+}
+
+// retryGuide tells automated clients whether repeating the request may
+// succeed, and after how long.
+type retryGuide struct {
+	Retryable    bool `json:"retryable"`
+	AfterSeconds int  `json:"after_seconds,omitempty"`
+}
+
+// retryableStatus reports whether a response status signals a temporary
+// condition worth retrying.
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// writeError writes a JSON error response. For retryable statuses the
+// suggested delay is taken from a Retry-After header the handler already
+// set, or else config.RetryAfter is used and the header set to match.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeErrorBody(w, status, msg, retryableStatus(status))
+}
+
+// writePermanentError writes an error response that tells clients not to
+// retry, whatever the status, for conditions that last as long as the
+// process does.
+func writePermanentError(w http.ResponseWriter, status int, msg string) {
+	writeErrorBody(w, status, msg, false)
+}
+
+func writeErrorBody(w http.ResponseWriter, status int, msg string, retryable bool) {
+	body := errorBody{Error: msg}
+	if retryable {
+		body.Retry.Retryable = true
+		seconds, err := strconv.Atoi(w.Header().Get("Retry-After"))
+		if err != nil || seconds < 0 {
+			seconds = int(config.RetryAfter.Seconds())
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		}
+		body.Retry.AfterSeconds = seconds
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
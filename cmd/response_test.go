@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func decodeErrorBody(t *testing.T, rec *httptest.ResponseRecorder) errorBody {
+	t.Helper()
+	var body errorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error body %q: %v", rec.Body, err)
+	}
+	return body
+}
+
+func TestWriteErrorRetryGuidance(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.RetryAfter = 5 * time.Second
+
+	rec := httptest.NewRecorder()
+	writeError(rec, http.StatusServiceUnavailable, "not ready")
+	body := decodeErrorBody(t, rec)
+	if !body.Retry.Retryable || body.Retry.AfterSeconds != 5 || rec.Header().Get("Retry-After") != "5" {
+		t.Errorf("503: body %+v, Retry-After %q; want retryable after 5s", body, rec.Header().Get("Retry-After"))
+	}
+
+	// A delay chosen by the handler is kept.
+	rec = httptest.NewRecorder()
+	rec.Header().Set("Retry-After", "2")
+	writeError(rec, http.StatusTooManyRequests, "rate limit exceeded")
+	if body := decodeErrorBody(t, rec); body.Retry.AfterSeconds != 2 || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("429: body %+v, Retry-After %q; want retryable after 2s", body, rec.Header().Get("Retry-After"))
+	}
+
+	rec = httptest.NewRecorder()
+	writeError(rec, http.StatusNotFound, "no person with that id")
+	if body := decodeErrorBody(t, rec); body.Retry.Retryable || body.Error != "no person with that id" || rec.Header().Get("Retry-After") != "" {
+		t.Errorf("404: body %+v, Retry-After %q; want not retryable", body, rec.Header().Get("Retry-After"))
+	}
+
+	rec = httptest.NewRecorder()
+	writePermanentError(rec, http.StatusServiceUnavailable, "read-only")
+	if body := decodeErrorBody(t, rec); body.Retry.Retryable || rec.Header().Get("Retry-After") != "" || rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("permanent 503: status %d, body %+v, Retry-After %q", rec.Code, body, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestMarshalExtJSON(t *testing.T) {
+	id, _ := primitive.ObjectIDFromHex("65f1c0a2b3c4d5e6f7a8b9c0")
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	person := Person{ID: id, Name: "Ann", CreatedAt: &created}
+
+	got, err := marshalExtJSON(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"_id":{"$oid":"65f1c0a2b3c4d5e6f7a8b9c0"}`,
+		`"created_at":{"$date":"2024-03-01T12:00:00Z"}`,
+		`"name":"Ann"`,
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("marshalExtJSON(person) = %s, want it to contain %s", got, want)
+		}
+	}
+
+	got, err = marshalExtJSON([]Person{person, {ID: id, Name: "Bob"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(got), `[{"_id":{"$oid":`) || strings.Count(string(got), `"$oid"`) != 2 {
+		t.Errorf("marshalExtJSON(people) = %s, want an array of two documents", got)
+	}
+	if got, err := marshalExtJSON([]Person{}); err != nil || string(got) != "[]" {
+		t.Errorf("marshalExtJSON(no people) = %s, %v; want []", got, err)
+	}
+}
+
+func TestWriteJSONExtJSON(t *testing.T) {
+	id, _ := primitive.ObjectIDFromHex("65f1c0a2b3c4d5e6f7a8b9c0")
+	person := Person{ID: id, Name: "Ann"}
+	for query, want := range map[string]string{
+		"":               `"id":"65f1c0a2b3c4d5e6f7a8b9c0"`,
+		"?ext_json=true": `"_id":{"$oid":"65f1c0a2b3c4d5e6f7a8b9c0"}`,
+	} {
+		rec := httptest.NewRecorder()
+		writeJSON(rec, httptest.NewRequest("GET", "/people/x"+query, nil), http.StatusOK, person)
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("writeJSON with %q = %s, want it to contain %s", query, rec.Body, want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// route is one entry of the routing table.
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+}
+
+// registerRoutes checks routes for conflicts and adds them to router in
+// order. Nothing is registered if there is a conflict.
+func registerRoutes(router *mux.Router, routes []route) error {
+	if err := checkRoutes(routes); err != nil {
+		return err
+	}
+	for _, rt := range routes {
+		router.HandleFunc(rt.path, rt.handler).Methods(rt.method)
+	}
+	return nil
+}
+
+// checkRoutes reports every route that can never be reached because an
+// earlier route with the same method matches all of its paths. mux uses
+// the first matching route, so a literal path such as /people/top must be
+// registered before /people/{id}, and two routes with the same shape (say
+// /people/{id} and /people/{key}) always conflict.
+func checkRoutes(routes []route) error {
+	var conflicts []string
+	for i, later := range routes {
+		for _, earlier := range routes[:i] {
+			if earlier.method == later.method && covers(earlier.path, later.path) {
+				conflicts = append(conflicts, fmt.Sprintf("%s %s is shadowed by %s %s",
+					later.method, later.path, earlier.method, earlier.path))
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return errors.New("conflicting routes: " + strings.Join(conflicts, "; "))
+	}
+	return nil
+}
+
+// covers reports whether every path matched by pattern b is also matched
+// by pattern a.
+func covers(a, b string) bool {
+	as, bs := strings.Split(strings.Trim(a, "/"), "/"), strings.Split(strings.Trim(b, "/"), "/")
+	if len(as) != len(bs) {
+		return false
+	}
+	for i := range as {
+		if isRouteVar(as[i]) {
+			continue
+		}
+		if isRouteVar(bs[i]) || as[i] != bs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isRouteVar(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCovers(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"/people/{id}", "/people/top", true},
+		{"/people/{id}", "/people/{key}", true},
+		{"/people/top", "/people/{id}", false},
+		{"/people/top", "/people/top", true},
+		{"/people/{id}", "/people/{id}/diff", false},
+		{"/people/{id}/diff", "/people/top/diff", true},
+		{"/people/{id}/diff", "/people/{id}/restore", false},
+		{"/people/", "/people", true},
+	}
+	for _, tt := range tests {
+		if got := covers(tt.a, tt.b); got != tt.want {
+			t.Errorf("covers(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRoutes(t *testing.T) {
+	ok := []route{
+		{"GET", "/people/top", nil},
+		{"GET", "/people/{id}", nil},
+		// Other methods don't conflict.
+		{"PUT", "/people/{id}", nil},
+		{"DELETE", "/people/{key}", nil},
+	}
+	if err := checkRoutes(ok); err != nil {
+		t.Errorf("checkRoutes: %v", err)
+	}
+
+	shadowed := []route{
+		{"GET", "/people/{id}", nil},
+		{"GET", "/people/top", nil},
+		{"GET", "/people/{key}", nil},
+	}
+	err := checkRoutes(shadowed)
+	if err == nil {
+		t.Fatal("checkRoutes accepted shadowed routes")
+	}
+	for _, want := range []string{"GET /people/top is shadowed by GET /people/{id}", "GET /people/{key} is shadowed by GET /people/{id}"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("checkRoutes error %q doesn't mention %q", err, want)
+		}
+	}
+}
+
+func TestRegisterRoutes(t *testing.T) {
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(name)) }
+	}
+
+	router := mux.NewRouter()
+	if err := registerRoutes(router, []route{
+		{"GET", "/people/top", handler("top")},
+		{"GET", "/people/{id}", handler("person")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{"/people/top": "top", "/people/abc": "person"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if got := rec.Body.String(); got != want {
+			t.Errorf("GET %s went to %q, want %q", path, got, want)
+		}
+	}
+
+	router = mux.NewRouter()
+	if err := registerRoutes(router, []route{
+		{"GET", "/people/{id}", handler("person")},
+		{"GET", "/people/top", handler("top")},
+	}); err == nil {
+		t.Error("registerRoutes accepted a shadowed route")
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/people/abc", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("a rejected table was partly registered: GET /people/abc answered %d", rec.Code)
+	}
+}
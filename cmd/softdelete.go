@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Policies for DELETE of a person who is already soft-deleted.
+const (
+	// redeleteNotFound answers 404, as a hard delete of a missing person
+	// does. This is the default.
+	redeleteNotFound = "not_found"
+	// redeleteNoop answers 204 without changing anything, so DELETE is
+	// idempotent.
+	redeleteNoop = "noop"
+)
+
+// liveFilter narrows filter to people who haven't been soft-deleted. It
+// returns filter as is when soft delete is off, or when filter already has
+// a deleted_at condition, as trashedFilter's do. $exists rather than an
+// equality with null keeps upserts from copying the condition into the
+// inserted document.
+func liveFilter(filter bson.M) bson.M {
+	if _, ok := filter["deleted_at"]; ok || !config.SoftDelete {
+		return filter
+	}
+	live := bson.M{"deleted_at": bson.M{"$exists": false}}
+	for k, v := range filter {
+		live[k] = v
+	}
+	return live
+}
+
+// trashedFilter narrows filter to soft-deleted people.
+func trashedFilter(filter bson.M) bson.M {
+	trashed := bson.M{"deleted_at": bson.M{"$exists": true}}
+	for k, v := range filter {
+		trashed[k] = v
+	}
+	return trashed
+}
+
+// removePerson deletes the person matching filter, or with soft delete on
+// sets its deleted_at, and returns how many people it removed.
+func removePerson(ctx context.Context, filter bson.M) (int64, error) {
+	collection := client.Database(Database).Collection(Collection)
+	if !config.SoftDelete {
+		result, err := collection.DeleteOne(ctx, filter)
+		if err != nil {
+			return 0, err
+		}
+		return result.DeletedCount, nil
+	}
+	deletedAt := now()
+	result, err := collection.UpdateOne(ctx, liveFilter(filter), bson.M{"$set": bson.M{
+		"deleted_at": deletedAt,
+		"updated_at": deletedAt,
+		"updated_by": actor(ctx),
+	}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// handleDeleteNoMatch responds to a DELETE that removed nothing. A person
+// who is already soft-deleted gets the config.RedeletePolicy outcome;
+// otherwise it is handleNoMatch.
+func handleDeleteNoMatch(ctx context.Context, w http.ResponseWriter, key bson.M, conditional bool) {
+	if config.SoftDelete {
+		collection := client.Database(Database).Collection(Collection)
+		err := collection.FindOne(ctx, trashedFilter(key)).Err()
+		switch {
+		case err == nil && config.RedeletePolicy == redeleteNoop:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case err == nil:
+			handleClientError(w, http.StatusNotFound, "person already deleted")
+			return
+		case !errors.Is(err, mongo.ErrNoDocuments):
+			handleError(w, err)
+			return
+		}
+	}
+	handleNoMatch(ctx, w, liveFilter(key), conditional)
+}
+
+// GetTrash lists the soft-deleted people, most recently deleted first,
+// filtered and paginated like GET /people.
+func GetTrash(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling GET request for /people/trash")
+	q, err := parsePeopleQuery(r.URL.Query())
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter, err := q.filter(r.Context())
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, size, err := q.pagination()
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sort := bson.D{{Key: "deleted_at", Value: -1}, {Key: "_id", Value: 1}}
+	after, err := q.afterFilter(sort)
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := findPeoplePage(r.Context(), trashedFilter(filter), after, sort, page, size, false)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	setPageHeaders(w, result)
+	writeJSON(w, r, http.StatusOK, result.Items)
+}
+
+// RestorePerson undoes a soft delete.
+func RestorePerson(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling POST request for /people/id/restore")
+	key, err := personKeyFilter(r.Context(), "id", mux.Vars(r)["id"])
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var person Person
+	collection := client.Database(Database).Collection(Collection)
+	err = collection.FindOneAndUpdate(r.Context(), trashedFilter(key),
+		bson.M{"$unset": bson.M{"deleted_at": ""}, "$set": bson.M{"updated_at": now(), "updated_by": actor(r.Context())}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&person)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		handleClientError(w, http.StatusNotFound, "no deleted person with that id")
+		return
+	}
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	writePerson(w, r, http.StatusOK, person)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestDeletePersonRedeletePolicy(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.SoftDelete = true
+	ns := Database + "." + Collection
+	id := primitive.NewObjectID()
+	trashed := bson.D{{Key: "_id", Value: id}, {Key: "name", Value: "Ann"}, {Key: "deleted_at", Value: primitive.NewDateTimeFromTime(*now())}}
+
+	tests := []struct {
+		name, policy string
+		modified     int32
+		found        []bson.D // the trashed person, if there is one
+		want         int
+	}{
+		{"first delete", redeleteNotFound, 1, nil, http.StatusNoContent},
+		{"again, not_found", redeleteNotFound, 0, []bson.D{trashed}, http.StatusNotFound},
+		{"again, noop", redeleteNoop, 0, []bson.D{trashed}, http.StatusNoContent},
+		{"never existed, noop", redeleteNoop, 0, nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		config.RedeletePolicy = tt.policy
+		runMock(t, tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: tt.modified}, bson.E{Key: "nModified", Value: tt.modified}),
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, tt.found...),
+			)
+			r := mux.SetURLVars(httptest.NewRequest("DELETE", "/people/"+id.Hex(), nil), map[string]string{"id": id.Hex()})
+			rec := httptest.NewRecorder()
+			DeletePerson(rec, r)
+			if rec.Code != tt.want {
+				mt.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+			// Soft-deleting sets deleted_at rather than removing the document.
+			if cmd := mt.GetStartedEvent(); cmd.CommandName != "update" {
+				mt.Errorf("DELETE ran %s, want update", cmd.CommandName)
+			}
+		})
+	}
+}
+
+func TestGetTrashFilters(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.SoftDelete = true
+
+	runMock(t, "by name", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, Database+"."+Collection, mtest.FirstBatch), countResponse(0))
+		rec := httptest.NewRecorder()
+		GetTrash(rec, httptest.NewRequest("GET", "/people/trash?name=Ann", nil))
+		if rec.Code != http.StatusOK {
+			mt.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		filter := mt.GetStartedEvent().Command.Lookup("filter").Document()
+		if name := filter.Lookup("name"); name.Type == 0 {
+			mt.Errorf("trash filter %s ignores name=Ann", filter)
+		}
+		if deleted, ok := filter.Lookup("deleted_at", "$exists").BooleanOK(); !ok || !deleted {
+			mt.Errorf("trash filter %s doesn't keep to deleted people", filter)
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	GetTrash(rec, httptest.NewRequest("GET", "/people/trash?min_age=old", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("min_age=old: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// numericFields lists the Person fields that aggregate endpoints accept.
+var numericFields = map[string]bool{
+	"age": true,
+}
+
+// percentileUnsupported is set once the server rejects the $percentile
+// accumulator (MongoDB < 7.0), so later requests skip straight to the
+// sort-based fallback.
+var percentileUnsupported atomic.Bool
+
+// GetPercentiles reports percentiles of a numeric field. On MongoDB 7.0 and
+// later they come from the $percentile accumulator's approximate method,
+// which may differ slightly from any stored value on large collections.
+// Older servers get exact nearest-rank percentiles instead (see
+// percentilesBySort), so the same request can answer differently once the
+// server is upgraded.
+func GetPercentiles(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling GET request for /people/percentiles")
+	field := r.URL.Query().Get("field")
+	if field == "" {
+		field = "age"
+	}
+	if !numericFields[field] {
+		handleClientError(w, http.StatusBadRequest, fmt.Sprintf("field %q is not a numeric field", field))
+		return
+	}
+	if !fieldVisible(r.Context(), field) {
+		handleClientError(w, http.StatusBadRequest, fmt.Sprintf("field %q is not available", field))
+		return
+	}
+
+	ps, err := parsePercentiles(r.URL.Query().Get("p"))
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	collection := client.Database(Database).Collection(Collection)
+	values, err := percentiles(r.Context(), collection, field, ps)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	result := make(map[string]*float64, len(ps))
+	for i, p := range ps {
+		result[strconv.FormatFloat(p, 'f', -1, 64)] = values[i]
+	}
+
+	writeJSON(w, r, http.StatusOK, result)
+}
+
+// GetTopPeople returns the people in the top percent of field among those
+// matching the GET /people filters, highest first and paginated like
+// GetPeople. Ties at the threshold are included, so a page can hold
+// slightly more than percent of the matching people.
+func GetTopPeople(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling GET request for /people/top")
+	values := r.URL.Query()
+	field := values.Get("field")
+	if field == "" {
+		field = "age"
+	}
+	if !numericFields[field] {
+		handleClientError(w, http.StatusBadRequest, fmt.Sprintf("field %q is not a numeric field", field))
+		return
+	}
+	if !fieldVisible(r.Context(), field) {
+		handleClientError(w, http.StatusBadRequest, fmt.Sprintf("field %q is not available", field))
+		return
+	}
+	percent, err := strconv.ParseFloat(values.Get("percent"), 64)
+	if err != nil || !(percent > 0 && percent < 100) {
+		handleClientError(w, http.StatusBadRequest, "percent must be a number in (0,100)")
+		return
+	}
+	q, err := parsePeopleQuery(values)
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, size, err := q.pagination()
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter, err := q.filter(r.Context())
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	collection := client.Database(Database).Collection(Collection)
+	threshold, err := topThreshold(r.Context(), collection, filter, field, percent)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	result := peoplePage{Items: []Person{}, Page: page, PageSize: size}
+	if threshold != nil {
+		filter := bson.M{"$and": []bson.M{filter, {field: bson.M{"$gte": *threshold}}}}
+		sort := bson.D{{Key: field, Value: -1}, {Key: "_id", Value: 1}}
+		after, err := q.afterFilter(sort)
+		if err != nil {
+			handleClientError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		result, err = findPeoplePage(r.Context(), filter, after, sort, page, size, false)
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+		w.Header().Set("X-Top-Threshold", strconv.FormatFloat(*threshold, 'f', -1, 64))
+	}
+
+	setPageHeaders(w, result)
+	writeJSON(w, r, http.StatusOK, result.Items)
+}
+
+// topThreshold returns the smallest value of field within the top percent
+// of the documents matching filter, or nil if none has a numeric value for
+// field.
+func topThreshold(ctx context.Context, collection *mongo.Collection, filter bson.M, field string, percent float64) (*float64, error) {
+	match := liveFilter(bson.M{"$and": []bson.M{filter, {field: bson.M{"$type": "number"}}}})
+	n, err := collection.CountDocuments(ctx, match, countOptions(ctx))
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	k := int64(math.Ceil(percent / 100 * float64(n)))
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: field, Value: -1}}}},
+		{{Key: "$skip", Value: k - 1}},
+		{{Key: "$limit", Value: 1}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "value": "$" + field}}},
+	}
+	cur, err := collection.Aggregate(ctx, pipeline, aggregateOptions(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	if !cur.Next(ctx) {
+		return nil, cur.Err()
+	}
+	var doc bson.M
+	if err := cur.Decode(&doc); err != nil {
+		return nil, err
+	}
+	v, ok := toFloat(doc["value"])
+	if !ok {
+		return nil, fmt.Errorf("field %q is not numeric", field)
+	}
+	return &v, nil
+}
+
+// groupFields lists the fields people can be grouped by.
+var groupFields = map[string]bool{
+	"name":    true,
+	"age":     true,
+	"address": true,
+	"email":   true,
+	"tags":    true,
+}
+
+// groupCount is one row of a group-by result.
+type groupCount struct {
+	Value interface{} `json:"value" bson:"_id"`
+	Count int64       `json:"count" bson:"count"`
+}
+
+// GroupPeople counts people per distinct value of field, largest groups
+// first. Results are streamed from the aggregation cursor as they arrive,
+// as NDJSON by default or as a JSON array with format=json, so that
+// high-cardinality fields don't have to fit in memory; a query that fails
+// partway cuts the connection. Array fields such as tags count each
+// element.
+func GroupPeople(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling GET request for /people/group-by")
+	field := r.URL.Query().Get("field")
+	if !groupFields[field] {
+		handleClientError(w, http.StatusBadRequest, fmt.Sprintf("cannot group by %q", field))
+		return
+	}
+	// Groups list the stored values, so a hidden field can't be grouped
+	// by any more than it can be read.
+	if !fieldVisible(r.Context(), field) {
+		handleClientError(w, http.StatusBadRequest, fmt.Sprintf("field %q is not available", field))
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "json" {
+		handleClientError(w, http.StatusBadRequest, "format must be ndjson or json")
+		return
+	}
+
+	var pipeline mongo.Pipeline
+	if config.SoftDelete {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: liveFilter(bson.M{})}})
+	}
+	if field == "tags" {
+		pipeline = append(pipeline, bson.D{{Key: "$unwind", Value: "$tags"}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	)
+
+	ctx := r.Context()
+	collection := client.Database(Database).Collection(Collection)
+	cur, err := collection.Aggregate(ctx, pipeline, streamAggregateOptions(ctx))
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	defer cur.Close(context.Background())
+
+	if format == "ndjson" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("["))
+	}
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	n := 0
+	for cur.Next(ctx) {
+		var row groupCount
+		if err := cur.Decode(&row); err != nil {
+			abortStream("groups", err)
+		}
+		if format == "json" && n > 0 {
+			w.Write([]byte(","))
+		}
+		if err := enc.Encode(row); err != nil {
+			log.Println("Client went away during group-by:", err)
+			return
+		}
+		if n++; n%100 == 0 && flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if err := cur.Err(); err != nil {
+		abortStream("groups", err)
+	}
+	if format == "json" {
+		w.Write([]byte("]\n"))
+	}
+}
+
+// parsePercentiles parses a comma separated list of percentiles, each of
+// which must lie in (0,100]. An empty list defaults to 50,90,99.
+func parsePercentiles(raw string) ([]float64, error) {
+	if raw == "" {
+		raw = "50,90,99"
+	}
+	var ps []float64
+	for _, part := range strings.Split(raw, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(p) {
+			return nil, fmt.Errorf("invalid percentile %q", part)
+		}
+		if p <= 0 || p > 100 {
+			return nil, fmt.Errorf("percentile %v must be in (0,100]", p)
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// percentiles returns the requested percentiles of field, in the same order
+// as ps. A nil entry means the collection has no numeric values for field.
+func percentiles(ctx context.Context, collection *mongo.Collection, field string, ps []float64) ([]*float64, error) {
+	if !percentileUnsupported.Load() {
+		values, err := percentilesAccumulator(ctx, collection, field, ps)
+		if !isUnsupportedOperator(err) {
+			return values, err
+		}
+		log.Println("Server does not support $percentile, falling back to sort-based percentiles")
+		percentileUnsupported.Store(true)
+	}
+	return percentilesBySort(ctx, collection, field, ps)
+}
+
+// percentilesAccumulator computes percentiles with $percentile. Its only
+// method is "approximate" (t-digest), so results are close to, but not
+// always exactly, the nearest-rank values.
+func percentilesAccumulator(ctx context.Context, collection *mongo.Collection, field string, ps []float64) ([]*float64, error) {
+	fractions := make([]float64, len(ps))
+	for i, p := range ps {
+		fractions[i] = p / 100
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: liveFilter(bson.M{field: bson.M{"$type": "number"}})}},
+		{{Key: "$group", Value: bson.M{
+			"_id": nil,
+			"values": bson.M{"$percentile": bson.M{
+				"input":  "$" + field,
+				"p":      fractions,
+				"method": "approximate",
+			}},
+		}}},
+	}
+	cur, err := collection.Aggregate(ctx, pipeline, aggregateOptions(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	values := make([]*float64, len(ps))
+	if !cur.Next(ctx) {
+		return values, cur.Err()
+	}
+	var doc struct {
+		Values []float64 `bson:"values"`
+	}
+	if err := cur.Decode(&doc); err != nil {
+		return nil, err
+	}
+	for i := range values {
+		if i < len(doc.Values) {
+			v := doc.Values[i]
+			values[i] = &v
+		}
+	}
+	return values, nil
+}
+
+// percentilesBySort computes exact nearest-rank percentiles, the value at
+// rank ceil(p/100 * n), by counting the matching documents and then
+// fetching the value at each rank.
+func percentilesBySort(ctx context.Context, collection *mongo.Collection, field string, ps []float64) ([]*float64, error) {
+	filter := liveFilter(bson.M{field: bson.M{"$type": "number"}})
+	n, err := collection.CountDocuments(ctx, filter, countOptions(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]*float64, len(ps))
+	if n == 0 {
+		return values, nil
+	}
+	for i, p := range ps {
+		rank := int64(math.Ceil(p / 100 * float64(n)))
+		if rank < 1 {
+			rank = 1
+		}
+		opts := findOneOptions(ctx).
+			SetSort(bson.D{{Key: field, Value: 1}}).
+			SetSkip(rank - 1).
+			SetProjection(bson.M{field: 1})
+		var doc bson.M
+		if err := collection.FindOne(ctx, filter, opts).Decode(&doc); err != nil {
+			return nil, err
+		}
+		v, ok := toFloat(doc[field])
+		if !ok {
+			return nil, fmt.Errorf("field %q is not numeric", field)
+		}
+		values[i] = &v
+	}
+	return values, nil
+}
+
+// isUnsupportedOperator reports whether err means the server does not know
+// an aggregation operator used in the pipeline.
+func isUnsupportedOperator(err error) bool {
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	switch cmdErr.Code {
+	case 15952, 168: // unknown group operator, InvalidPipelineOperator
+		return true
+	}
+	return strings.Contains(cmdErr.Message, "$percentile")
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestParsePercentiles(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []float64
+	}{
+		{"", []float64{50, 90, 99}},
+		{"50", []float64{50}},
+		{" 25 , 99.9,100", []float64{25, 99.9, 100}},
+	}
+	for _, tt := range tests {
+		got, err := parsePercentiles(tt.raw)
+		if err != nil {
+			t.Errorf("parsePercentiles(%q): %v", tt.raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePercentiles(%q) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestParsePercentilesRejects(t *testing.T) {
+	for _, raw := range []string{"0", "-1", "100.5", "NaN", "abc", "50,", ",50"} {
+		if got, err := parsePercentiles(raw); err == nil {
+			t.Errorf("parsePercentiles(%q) = %v, want an error", raw, got)
+		}
+	}
+}
+
+func TestToFloat(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want float64
+		ok   bool
+	}{
+		{int32(3), 3, true},
+		{int64(-4), -4, true},
+		{2.5, 2.5, true},
+		{"7", 0, false},
+		{nil, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := toFloat(tt.v)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("toFloat(%#v) = %v, %v; want %v, %v", tt.v, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTopThreshold(t *testing.T) {
+	ns := Database + "." + Collection
+	tests := []struct {
+		count   int64
+		percent float64
+		skip    int64
+	}{
+		// The threshold is the k-th highest value, k = ceil(percent of count).
+		{10, 25, 2},
+		{10, 10, 0},
+		{3, 50, 1},
+		{1, 1, 0},
+	}
+	for _, tt := range tests {
+		runMock(t, fmt.Sprintf("%d people, top %v%%", tt.count, tt.percent), func(mt *mtest.T) {
+			mt.AddMockResponses(countResponse(tt.count),
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "value", Value: int32(40)}}))
+			collection := client.Database(Database).Collection(Collection)
+			threshold, err := topThreshold(context.Background(), collection, bson.M{}, "age", tt.percent)
+			if err != nil {
+				mt.Fatal(err)
+			}
+			if threshold == nil || *threshold != 40 {
+				mt.Errorf("threshold = %v, want 40", threshold)
+			}
+			pipeline := mt.GetAllStartedEvents()[1].Command.Lookup("pipeline").Array()
+			if skip := pipeline.Index(2).Value().Document().Lookup("$skip").AsInt64(); skip != tt.skip {
+				mt.Errorf("pipeline %s skips %d, want %d", pipeline, skip, tt.skip)
+			}
+		})
+	}
+
+	runMock(t, "nobody", func(mt *mtest.T) {
+		mt.AddMockResponses(countResponse(0))
+		collection := client.Database(Database).Collection(Collection)
+		if threshold, err := topThreshold(context.Background(), collection, bson.M{}, "age", 10); threshold != nil || err != nil {
+			mt.Errorf("threshold with nobody counted = %v, %v; want none", threshold, err)
+		}
+	})
+}
+
+func TestPercentilesBySortFallback(t *testing.T) {
+	defer percentileUnsupported.Store(percentileUnsupported.Load())
+	percentileUnsupported.Store(false)
+	ns := Database + "." + Collection
+
+	// Ages 1 to 100, so the nearest-rank percentile p is the value ceil(p).
+	ps := []float64{50, 90, 99, 100, 0.5, 12.5}
+	want := []float64{50, 90, 99, 100, 1, 13}
+	runMock(t, "ages 1 to 100", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 15952, Message: "unknown group operator '$percentile'"}),
+			countResponse(100),
+		)
+		for _, v := range want {
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "age", Value: int32(v)}}))
+		}
+
+		collection := client.Database(Database).Collection(Collection)
+		values, err := percentiles(context.Background(), collection, "age", ps)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if !percentileUnsupported.Load() {
+			mt.Error("the unsupported $percentile wasn't remembered")
+		}
+		finds := mt.GetAllStartedEvents()[2:]
+		for i, p := range ps {
+			if values[i] == nil || *values[i] != want[i] {
+				mt.Errorf("percentile %v = %v, want %v", p, values[i], want[i])
+			}
+			if skip := finds[i].Command.Lookup("skip").AsInt64(); skip != int64(want[i])-1 {
+				mt.Errorf("percentile %v skips %d values, want %d", p, skip, int64(want[i])-1)
+			}
+		}
+	})
+}
+
+func TestPercentilesAllowDiskUse(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer percentileUnsupported.Store(percentileUnsupported.Load())
+	percentileUnsupported.Store(false)
+
+	for _, allow := range []bool{true, false} {
+		config.AllowDiskUse = allow
+		runMock(t, fmt.Sprint(allow), func(mt *mtest.T) {
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, Database+"."+Collection, mtest.FirstBatch,
+				bson.D{{Key: "values", Value: bson.A{30.0}}}))
+			collection := client.Database(Database).Collection(Collection)
+			if _, err := percentiles(context.Background(), collection, "age", []float64{50}); err != nil {
+				mt.Fatal(err)
+			}
+			aggregate := mt.GetStartedEvent()
+			if got, ok := aggregate.Command.Lookup("allowDiskUse").BooleanOK(); !ok || got != allow {
+				mt.Errorf("aggregate command %s, want allowDiskUse %v", aggregate.Command, allow)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// streamHeartbeat is how often an idle stream sends a comment line, so
+// that proxies don't close it.
+const streamHeartbeat = 30 * time.Second
+
+// changeEvent is the part of a change stream event that is sent on.
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ID primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument *Person `bson:"fullDocument"`
+}
+
+// StreamPeople sends changes to people as server-sent events, one per
+// insert, update, replace or delete. The event name is the operation and
+// the data holds the person's id and, except for deletes, the person as it
+// is after the change.
+//
+// filter[...] parameters, as accepted by GET /people, restrict the stream
+// to changes whose resulting document matches. Deleted documents can't be
+// matched, so a filtered stream carries no delete events; soft deletes are
+// updates and are matched like any other.
+func StreamPeople(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling GET request for /people/stream")
+	match := bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}}}
+	where, err := filterParams(r.URL.Query())
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if where != nil {
+		filter, err := compileWhere(r.Context(), where)
+		if err != nil {
+			handleClientError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		match = bson.M{"$and": bson.A{match, changeStreamFilter(filter)}}
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stop := context.AfterFunc(shutdownCtx, cancel)
+	defer stop()
+	done := trackWorker("people-stream")
+	defer done()
+
+	collection := client.Database(Database).Collection(Collection)
+	cs, err := collection.Watch(ctx, mongo.Pipeline{{{Key: "$match", Value: match}}},
+		options.ChangeStream().SetFullDocument(options.UpdateLookup).SetMaxAwaitTime(time.Second))
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	defer cs.Close(context.Background())
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	lastSent := time.Now()
+	for {
+		if !cs.TryNext(ctx) {
+			if cs.Err() != nil || ctx.Err() != nil {
+				break
+			}
+			if time.Since(lastSent) >= streamHeartbeat {
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+				rc.Flush()
+				lastSent = time.Now()
+			}
+			continue
+		}
+
+		var ev changeEvent
+		if err := cs.Decode(&ev); err != nil {
+			log.Println("Error decoding change event:", err)
+			continue
+		}
+		data := map[string]interface{}{"id": ev.DocumentKey.ID}
+		if ev.FullDocument != nil {
+			person, err := restrictFields(ctx, inZone(ctx, *ev.FullDocument), false)
+			if err != nil {
+				log.Println("Error encoding change event:", err)
+				continue
+			}
+			data["person"] = person
+		}
+		body, err := json.Marshal(data)
+		if err != nil {
+			log.Println("Error encoding change event:", err)
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.OperationType, body); err != nil {
+			return
+		}
+		rc.Flush()
+		lastSent = time.Now()
+	}
+	if err := cs.Err(); err != nil && ctx.Err() == nil {
+		// Headers are already sent; all we can do is stop.
+		log.Println("Error streaming changes:", err)
+	}
+}
+
+// changeStreamFilter moves a people filter onto the fullDocument of change
+// events.
+func changeStreamFilter(filter bson.M) bson.M {
+	out := bson.M{}
+	for key, val := range filter {
+		if logicalOps[key] {
+			clauses := val.([]bson.M)
+			moved := make([]bson.M, len(clauses))
+			for i, clause := range clauses {
+				moved[i] = changeStreamFilter(clause)
+			}
+			out[key] = moved
+			continue
+		}
+		out["fullDocument."+key] = val
+	}
+	return out
+}
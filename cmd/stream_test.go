@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestChangeStreamFilter(t *testing.T) {
+	filter := bson.M{
+		"name": "Ann",
+		"$or":  []bson.M{{"age": bson.M{"$gt": int64(3)}}, {"$and": []bson.M{{"tags": "vip"}}}},
+	}
+	want := bson.M{
+		"fullDocument.name": "Ann",
+		"$or": []bson.M{
+			{"fullDocument.age": bson.M{"$gt": int64(3)}},
+			{"$and": []bson.M{{"fullDocument.tags": "vip"}}},
+		},
+	}
+	if got := changeStreamFilter(filter); !reflect.DeepEqual(got, want) {
+		t.Errorf("changeStreamFilter = %v, want %v", got, want)
+	}
+}
+
+// Filters from the query string compile to something changeStreamFilter
+// takes apart.
+func TestChangeStreamFilterFromParams(t *testing.T) {
+	filter, err := peopleQuery(t, "filter[or][0][name]=Ann&filter[or][1][age][lt]=18").filter(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{"$and": []bson.M{{"$or": []bson.M{
+		{"fullDocument.name": bson.M{"$eq": "Ann"}},
+		{"fullDocument.age": bson.M{"$lt": int64(18)}},
+	}}}}
+	if got := changeStreamFilter(filter); !reflect.DeepEqual(got, want) {
+		t.Errorf("changeStreamFilter = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// tagRequest is the body of POST /people/tag and /people/untag.
+type tagRequest struct {
+	Filter PeopleQuery `json:"filter"`
+	Tags   []string    `json:"tags"`
+
+	// Confirm must be set to apply the change to every person, which is
+	// what an empty filter selects.
+	Confirm bool `json:"confirm"`
+}
+
+func TagPeople(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling POST request for /people/tag")
+	updateTags(w, r, "$addToSet")
+}
+
+func UntagPeople(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling POST request for /people/untag")
+	updateTags(w, r, "$pull")
+}
+
+// updateTags applies op ($addToSet or $pull) with the requested tags to
+// every person matching the filter.
+func updateTags(w http.ResponseWriter, r *http.Request, op string) {
+	var req tagRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		handleClientError(w, http.StatusBadRequest, "malformed JSON: "+err.Error())
+		return
+	}
+	// Cleaned as in a PUT, so both ways of tagging store the same value.
+	if err := sanitizeTags("tags", req.Tags); err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateTags(req.Tags); err != nil {
+		handleClientError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	filter, err := req.Filter.filter(r.Context())
+	if err != nil {
+		handleClientError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(filter) == 0 && !req.Confirm {
+		handleClientError(w, http.StatusBadRequest, "empty filter matches every person; set confirm to true to proceed")
+		return
+	}
+	filter = liveFilter(filter)
+
+	req.Tags = normalizeTags(req.Tags)
+
+	if op == "$addToSet" {
+		if err := checkTagLimits(req.Tags); err != nil {
+			handleClientError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// Only the people the request changes are written, so that they alone
+	// get a new updated_at; the rest of the filter still counts as matched.
+	change := bson.M{"tags": bson.M{"$in": req.Tags}}
+	update := bson.M{"$pull": bson.M{"tags": bson.M{"$in": req.Tags}}}
+	if op == "$addToSet" {
+		change = bson.M{"tags": bson.M{"$not": bson.M{"$all": req.Tags}}}
+		update = bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": req.Tags}}}
+	}
+	update["$set"] = bson.M{"updated_at": now(), "updated_by": actor(r.Context())}
+
+	collection := client.Database(Database).Collection(Collection)
+	var matched, modified, over int64
+	err = withTransaction(r.Context(), func(sc mongo.SessionContext) error {
+		var err error
+		if matched, err = collection.CountDocuments(sc, filter); err != nil || matched == 0 {
+			return err
+		}
+		// Refuse the whole request if any matching person would end up
+		// over the limit, rather than tagging only some of them.
+		if op == "$addToSet" && config.MaxTags > 0 {
+			over, err = collection.CountDocuments(sc, bson.M{"$and": []bson.M{filter, {"$expr": bson.M{"$gt": bson.A{
+				bson.M{"$size": bson.M{"$setUnion": bson.A{bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}, req.Tags}}},
+				config.MaxTags,
+			}}}}})
+			if err != nil || over > 0 {
+				return err
+			}
+		}
+		target := bson.M{"$and": []bson.M{filter, change}}
+		resort := op == "$addToSet" && config.SortTags
+		if resort {
+			// $addToSet appends, and can't be combined with $sort on the
+			// same field, so the arrays that gain tags are re-sorted in a
+			// second update. Filters like missing=tags stop matching after
+			// the first one, so both are aimed at the ids read here.
+			ids, err := collection.Distinct(sc, "_id", target)
+			if err != nil || len(ids) == 0 {
+				return err
+			}
+			target = bson.M{"_id": bson.M{"$in": ids}}
+		}
+		result, err := collection.UpdateMany(sc, target, update)
+		if err != nil {
+			return err
+		}
+		modified = result.ModifiedCount
+		if modified == 0 || !resort {
+			return nil
+		}
+		_, err = collection.UpdateMany(sc, target,
+			bson.M{"$push": bson.M{"tags": bson.M{"$each": []string{}, "$sort": 1}}})
+		return err
+	})
+	if err == nil && over > 0 {
+		handleClientError(w, http.StatusBadRequest, fmt.Sprintf("%d matching people would have more than %d tags", over, config.MaxTags))
+		return
+	}
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]int64{
+		"matched":  matched,
+		"modified": modified,
+	})
+}
+
+// checkTagLimits enforces config.MaxTags and config.MaxTagLength on the
+// tags of one person, or of one tagging request. Lengths are counted in
+// characters.
+func checkTagLimits(tags []string) error {
+	if config.MaxTags > 0 && len(tags) > config.MaxTags {
+		return fmt.Errorf("at most %d tags are allowed, got %d", config.MaxTags, len(tags))
+	}
+	if config.MaxTagLength > 0 {
+		for _, tag := range tags {
+			if n := utf8.RuneCountInString(tag); n > config.MaxTagLength {
+				return fmt.Errorf("tag %q is %d characters long; the limit is %d", tag, n, config.MaxTagLength)
+			}
+		}
+	}
+	return nil
+}
+
+func validateTags(tags []string) error {
+	if len(tags) == 0 {
+		return fmt.Errorf("tags must not be empty")
+	}
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) == "" {
+			return fmt.Errorf("tags must not contain blank values")
+		}
+	}
+	return nil
+}
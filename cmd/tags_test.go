@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateTags(t *testing.T) {
+	if err := validateTags([]string{"a", "b"}); err != nil {
+		t.Errorf("validateTags: %v", err)
+	}
+	for _, tags := range [][]string{nil, {}, {"a", " "}, {""}} {
+		if err := validateTags(tags); err == nil {
+			t.Errorf("validateTags(%q) accepted", tags)
+		}
+	}
+}
+
+func TestCheckTagLimits(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.MaxTags, config.MaxTagLength = 3, 5
+
+	tests := []struct {
+		tags []string
+		err  string
+	}{
+		{[]string{"a", "b", "c"}, ""},
+		{[]string{"a", "b", "c", "d"}, "at most 3 tags are allowed, got 4"},
+		// Characters are counted, not bytes.
+		{[]string{"héllo"}, ""},
+		{[]string{"ok", "toolong"}, `tag "toolong" is 7 characters long; the limit is 5`},
+	}
+	for _, tt := range tests {
+		err := checkTagLimits(tt.tags)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("checkTagLimits(%q): %v", tt.tags, err)
+		case tt.err != "" && (err == nil || err.Error() != tt.err):
+			t.Errorf("checkTagLimits(%q) error = %v, want %q", tt.tags, err, tt.err)
+		}
+	}
+
+	config.MaxTags, config.MaxTagLength = 0, 0
+	if err := checkTagLimits([]string{"a", "b", "c", "d", strings.Repeat("x", 1000)}); err != nil {
+		t.Errorf("checkTagLimits without limits: %v", err)
+	}
+}
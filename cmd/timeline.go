@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// timelineMaxBuckets bounds the size of a timeline response.
+const timelineMaxBuckets = 1000
+
+// timelineDefaultBuckets is how far back a timeline without from goes.
+const timelineDefaultBuckets = 30
+
+// timelineUnits are the granularities a timeline may be bucketed by. Weeks
+// start on Monday.
+var timelineUnits = map[string]bool{"hour": true, "day": true, "week": true, "month": true}
+
+// timelineBucket is the number of people created in the bucket starting at
+// Start.
+type timelineBucket struct {
+	Start time.Time `json:"start"`
+	Count int64     `json:"count"`
+}
+
+// GetTimeline counts the people created per hour, day, week or month
+// between from (inclusive) and to (exclusive). Buckets follow the clock
+// of the response zone (see tz) and every bucket in the range is listed,
+// with a zero count if no one was created in it. People without a
+// created_at are left out.
+func GetTimeline(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handling GET request for /people/timeline")
+	values := r.URL.Query()
+	unit := values.Get("granularity")
+	if unit == "" {
+		unit = "day"
+	}
+	if !timelineUnits[unit] {
+		handleClientError(w, http.StatusBadRequest, "granularity must be one of hour, day, week or month")
+		return
+	}
+
+	ctx := r.Context()
+	loc := responseZone(ctx)
+	to := time.Now().In(loc)
+	if s := values.Get("to"); s != "" {
+		t, err := parseTimelineTime(s, loc)
+		if err != nil {
+			handleClientError(w, http.StatusBadRequest, "to: "+err.Error())
+			return
+		}
+		to = t
+	}
+	var from time.Time
+	if s := values.Get("from"); s != "" {
+		t, err := parseTimelineTime(s, loc)
+		if err != nil {
+			handleClientError(w, http.StatusBadRequest, "from: "+err.Error())
+			return
+		}
+		from = truncateTime(t, unit)
+	} else {
+		from = truncateTime(to, unit)
+		for i := 1; i < timelineDefaultBuckets; i++ {
+			from = previousBucket(from, unit)
+		}
+	}
+	if !from.Before(to) {
+		handleClientError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+
+	var buckets []timelineBucket
+	for t := from; t.Before(to); t = nextBucket(t, unit) {
+		if len(buckets) == timelineMaxBuckets {
+			handleClientError(w, http.StatusBadRequest, fmt.Sprintf("the range spans more than %d buckets; narrow it or use a coarser granularity", timelineMaxBuckets))
+			return
+		}
+		buckets = append(buckets, timelineBucket{Start: t})
+	}
+
+	trunc := bson.M{"date": "$created_at", "unit": unit, "timezone": loc.String()}
+	if unit == "week" {
+		trunc["startOfWeek"] = "monday"
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: liveFilter(bson.M{"created_at": bson.M{"$gte": from, "$lt": to}})}},
+		{{Key: "$group", Value: bson.M{"_id": bson.M{"$dateTrunc": trunc}, "count": bson.M{"$sum": 1}}}},
+	}
+	collection := client.Database(Database).Collection(Collection)
+	cur, err := collection.Aggregate(ctx, pipeline, aggregateOptions(ctx))
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	var rows []struct {
+		Start time.Time `bson:"_id"`
+		Count int64     `bson:"count"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		handleError(w, err)
+		return
+	}
+	counts := make(map[int64]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Start.UnixMilli()] = row.Count
+	}
+	for i := range buckets {
+		buckets[i].Count = counts[buckets[i].Start.UnixMilli()]
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"granularity": unit,
+		"from":        from,
+		"to":          to,
+		"buckets":     buckets,
+	})
+}
+
+// parseTimelineTime accepts an RFC 3339 timestamp, or a date, which is
+// midnight in loc.
+func parseTimelineTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.In(loc), nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, loc); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not an RFC 3339 timestamp or a YYYY-MM-DD date", s)
+}
+
+// truncateTime returns the start of the bucket holding t, on t's clock.
+func truncateTime(t time.Time, unit string) time.Time {
+	y, m, d := t.Date()
+	switch unit {
+	case "hour":
+		return t.Truncate(time.Minute).Add(-time.Duration(t.Minute()) * time.Minute)
+	case "week":
+		d -= (int(t.Weekday()) + 6) % 7
+	case "month":
+		d = 1
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// nextBucket returns the start of the bucket after the one starting at t.
+// Days, weeks and months are stepped on the clock, so they stay aligned
+// to midnight across DST changes; hours are stepped in real time, so the
+// repeated hour of a DST change is its own bucket.
+func nextBucket(t time.Time, unit string) time.Time {
+	y, m, d := t.Date()
+	switch unit {
+	case "hour":
+		return t.Add(time.Hour)
+	case "week":
+		return time.Date(y, m, d+7, 0, 0, 0, 0, t.Location())
+	case "month":
+		return time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+}
+
+// previousBucket is the inverse of nextBucket.
+func previousBucket(t time.Time, unit string) time.Time {
+	y, m, d := t.Date()
+	switch unit {
+	case "hour":
+		return t.Add(-time.Hour)
+	case "week":
+		return time.Date(y, m, d-7, 0, 0, 0, 0, t.Location())
+	case "month":
+		return time.Date(y, m-1, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(y, m, d-1, 0, 0, 0, 0, t.Location())
+}
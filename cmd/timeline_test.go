@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimelineBucketsAcrossDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v.In(ny)
+	}
+
+	// Clocks went forward at 2:00 on 2024-03-10 and back at 2:00 on
+	// 2024-11-03.
+	tests := []struct {
+		name  string
+		unit  string
+		t     string
+		start string
+		next  string
+	}{
+		{"day of spring forward", "day", "2024-03-10T15:30:00-04:00", "2024-03-10T00:00:00-05:00", "2024-03-11T00:00:00-04:00"},
+		{"day of fall back", "day", "2024-11-03T23:00:00-05:00", "2024-11-03T00:00:00-04:00", "2024-11-04T00:00:00-05:00"},
+		{"week across spring forward", "week", "2024-03-10T12:00:00-04:00", "2024-03-04T00:00:00-05:00", "2024-03-11T00:00:00-04:00"},
+		{"month across spring forward", "month", "2024-03-31T23:59:00-04:00", "2024-03-01T00:00:00-05:00", "2024-04-01T00:00:00-04:00"},
+		{"hour before the skipped one", "hour", "2024-03-10T01:59:00-05:00", "2024-03-10T01:00:00-05:00", "2024-03-10T03:00:00-04:00"},
+		{"first of the repeated hours", "hour", "2024-11-03T01:30:00-04:00", "2024-11-03T01:00:00-04:00", "2024-11-03T01:00:00-05:00"},
+		{"second of the repeated hours", "hour", "2024-11-03T01:30:00-05:00", "2024-11-03T01:00:00-05:00", "2024-11-03T02:00:00-05:00"},
+	}
+	for _, tt := range tests {
+		start := truncateTime(at(tt.t), tt.unit)
+		if !start.Equal(at(tt.start)) {
+			t.Errorf("%s: truncateTime = %v, want %v", tt.name, start, at(tt.start))
+		}
+		next := nextBucket(start, tt.unit)
+		if !next.Equal(at(tt.next)) {
+			t.Errorf("%s: nextBucket = %v, want %v", tt.name, next, at(tt.next))
+		}
+		if prev := previousBucket(next, tt.unit); !prev.Equal(start) {
+			t.Errorf("%s: previousBucket(%v) = %v, want %v", tt.name, next, prev, start)
+		}
+	}
+}
+
+func TestTruncateTimeHalfHourZone(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := truncateTime(time.Date(2024, 5, 1, 10, 45, 30, 0, kolkata), "hour")
+	if want := time.Date(2024, 5, 1, 10, 0, 0, 0, kolkata); !got.Equal(want) {
+		t.Errorf("truncateTime = %v, want %v", got, want)
+	}
+}
+
+func TestParseTimelineTime(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		s    string
+		want time.Time
+	}{
+		{"2024-03-10", time.Date(2024, 3, 10, 0, 0, 0, 0, ny)},
+		{"2024-03-10T12:00:00Z", time.Date(2024, 3, 10, 8, 0, 0, 0, ny)},
+	}
+	for _, tt := range tests {
+		got, err := parseTimelineTime(tt.s, ny)
+		if err != nil || !got.Equal(tt.want) || got.Location() != ny {
+			t.Errorf("parseTimelineTime(%q) = %v, %v; want %v", tt.s, got, err, tt.want)
+		}
+	}
+	for _, s := range []string{"2024-03-10 12:00", "yesterday", "2024-13-01"} {
+		if _, err := parseTimelineTime(s, ny); err == nil {
+			t.Errorf("parseTimelineTime(%q) accepted", s)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	// Embed the tz database so zones resolve on hosts without one.
+	_ "time/tzdata"
+)
+
+type zoneKey struct{}
+
+// loadZone resolves an IANA time zone name. "Local" is refused, since it
+// would depend on the server's own setting.
+func loadZone(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// timezoneMiddleware reads the tz parameter, the zone timestamps are
+// rendered in, and stores it in the request context.
+func timezoneMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("tz")
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		loc, err := loadZone(name)
+		if err != nil {
+			handleClientError(w, http.StatusBadRequest, "tz: "+err.Error()+"; expected a name such as America/New_York")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), zoneKey{}, loc)))
+	})
+}
+
+// responseZone returns the zone requested with tz, or config.Timezone.
+func responseZone(ctx context.Context) *time.Location {
+	if loc, ok := ctx.Value(zoneKey{}).(*time.Location); ok {
+		return loc
+	}
+	return config.zone
+}
+
+// inZone returns v, a Person, []Person or peoplePage, with its timestamps
+// in the response zone. Only the rendering changes: the instants are the
+// same, and stored timestamps stay in UTC. Extended JSON dates are always
+// written in UTC, so this only shows in plain JSON and CSV.
+func inZone(ctx context.Context, v interface{}) interface{} {
+	loc := responseZone(ctx)
+	if loc == time.UTC {
+		return v
+	}
+	switch t := v.(type) {
+	case Person:
+		return personInZone(t, loc)
+	case []Person:
+		out := make([]Person, len(t))
+		for i, p := range t {
+			out[i] = personInZone(p, loc)
+		}
+		return out
+	case peoplePage:
+		t.Items = inZone(ctx, t.Items).([]Person)
+		return t
+	}
+	return v
+}
+
+func personInZone(p Person, loc *time.Location) Person {
+	p.CreatedAt = timeIn(p.CreatedAt, loc)
+	p.UpdatedAt = timeIn(p.UpdatedAt, loc)
+	p.DeletedAt = timeIn(p.DeletedAt, loc)
+	return p
+}
+
+func timeIn(t *time.Time, loc *time.Location) *time.Time {
+	if t == nil {
+		return nil
+	}
+	local := t.In(loc)
+	return &local
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadZone(t *testing.T) {
+	if loc, err := loadZone("Europe/Berlin"); err != nil || loc.String() != "Europe/Berlin" {
+		t.Errorf("loadZone(Europe/Berlin) = %v, %v", loc, err)
+	}
+	// Local depends on the host, so it isn't accepted.
+	for _, name := range []string{"", "Local", "Mars/Olympus"} {
+		if _, err := loadZone(name); err == nil {
+			t.Errorf("loadZone(%q) accepted", name)
+		}
+	}
+}
+
+func TestTimezoneMiddleware(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.zone = time.UTC
+
+	var zone *time.Location
+	handler := timezoneMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zone = responseZone(r.Context())
+	}))
+	for target, want := range map[string]string{"/people": "UTC", "/people?tz=Asia/Tokyo": "Asia/Tokyo"} {
+		zone = nil
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		if rec.Code != http.StatusOK || zone == nil || zone.String() != want {
+			t.Errorf("GET %s: status %d, zone %v; want %s", target, rec.Code, zone, want)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/people?tz=EST5EDT,M3", nil))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "America/New_York") {
+		t.Errorf("bad tz: status %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestInZone(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := Person{Name: "Ann", CreatedAt: &created}
+
+	config.zone = tokyo
+	got := inZone(context.Background(), []Person{p}).([]Person)
+	raw, err := json.Marshal(got[0].CreatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"2024-01-02T12:04:05+09:00"`; string(raw) != want {
+		t.Errorf("created_at in Tokyo = %s, want %s", raw, want)
+	}
+	if !got[0].CreatedAt.Equal(created) || p.CreatedAt.Location() != time.UTC {
+		t.Error("inZone changed the instant or the caller's person")
+	}
+
+	config.zone = time.UTC
+	if got := inZone(context.Background(), p).(Person); got.CreatedAt != p.CreatedAt {
+		t.Error("inZone copied a person already in UTC")
+	}
+}
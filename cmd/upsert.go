@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PUT /people/{id} where {id} is a natural key (see personFilter) is an
+// upsert. The on_conflict parameter says what to do when a person with
+// that key already exists:
+//
+//	no match                       -> insert, 201 Created
+//	match, on_conflict=update      -> replace, 200 OK (the default)
+//	match, on_conflict=reject      -> nothing written, 409 Conflict
+//	body has a different key       -> nothing written, 409 Conflict
+//
+// PUT by ObjectID never inserts: it updates (200) or answers 404, and
+// on_conflict does not apply.
+const (
+	onConflictUpdate = "update"
+	onConflictReject = "reject"
+)
+
+func parseOnConflict(r *http.Request) (string, bool) {
+	switch mode := r.URL.Query().Get("on_conflict"); mode {
+	case "", onConflictUpdate:
+		return onConflictUpdate, true
+	case onConflictReject:
+		return onConflictReject, true
+	}
+	return "", false
+}
+
+// setNaturalKey fills the natural key field from the path when the body
+// left it empty, so an inserted document can be found again by its key.
+// It reports false if the body has a different key: a PUT can't move a
+// person to another key, which would also make the path name nobody.
+func setNaturalKey(p *Person, key string) bool {
+	var field *string
+	switch config.NaturalKey {
+	case "name":
+		field = &p.Name
+	case "address":
+		field = &p.Address
+	case "email":
+		field = &p.Email
+	default:
+		return true
+	}
+	if *field == "" {
+		*field = key
+	}
+	return *field == key
+}
+
+// naturalKeyValue returns p's value for the natural key field.
+func naturalKeyValue(p Person) string {
+	switch config.NaturalKey {
+	case "name":
+		return p.Name
+	case "address":
+		return p.Address
+	case "email":
+		return p.Email
+	}
+	return ""
+}
+
+// createIfNotExists inserts doc, the stored form of person, unless a person
+// with the same natural key exists, which answers 409. The check and the
+// insert are one upsert with $setOnInsert, so concurrent creates can't both
+// get through: at worst one fails on the unique index, which is also a 409.
+func createIfNotExists(w http.ResponseWriter, r *http.Request, person Person, doc bson.M) {
+	key := naturalKeyValue(person)
+	if key == "" {
+		handleClientError(w, http.StatusUnprocessableEntity, config.NaturalKey+" is required with if_not_exists")
+		return
+	}
+
+	collection := client.Database(Database).Collection(Collection)
+	filter := bson.M{config.NaturalKey: key}
+	result, err := collection.UpdateOne(r.Context(), filter, bson.M{"$setOnInsert": doc}, options.Update().SetUpsert(true))
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	if result.UpsertedID == nil {
+		handleClientError(w, http.StatusConflict, "a person with that "+config.NaturalKey+" already exists")
+		return
+	}
+	person.ID, _ = result.UpsertedID.(primitive.ObjectID)
+	writeJSON(w, r, http.StatusCreated, person)
+}
+
+// errPersonExists aborts an on_conflict=reject upsert that found a person.
+var errPersonExists = errors.New("person exists")
+
+// errKeyMismatch aborts an upsert whose body names a different natural key
+// than the path.
+var errKeyMismatch = errors.New("natural key mismatch")
+
+// upsertPerson writes person, as read from the request, under the natural
+// key filter for key, following the on_conflict mode. An insert runs the
+// insert hook before checking the person; an update only checks it and,
+// like PUT by ObjectID, replaces the whole stored document, keeping only
+// its server managed fields. person's UpdatedAt and UpdatedBy must already
+// be set.
+func upsertPerson(w http.ResponseWriter, r *http.Request, filter bson.M, key string, person Person, mode string) {
+	// The read and the write share a transaction so a concurrent write
+	// can't slip in between. Concurrent inserts of the same key still meet
+	// at the unique index, which answers 409.
+	collection := client.Database(Database).Collection(Collection)
+	var stored Person
+	var inserted, changed bool
+	err := withTransaction(r.Context(), func(sc mongo.SessionContext) error {
+		inserted, changed = false, false
+		var existing Person
+		err := collection.FindOne(sc, filter).Decode(&existing)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			created := person
+			if err := prepareInsert(&created); err != nil {
+				return err
+			}
+			if !setNaturalKey(&created, key) {
+				return errKeyMismatch
+			}
+			created.ID = primitive.NewObjectID()
+			created.CreatedAt, created.CreatedBy = person.UpdatedAt, person.UpdatedBy
+			doc, err := storedPerson(created)
+			if err != nil {
+				return err
+			}
+			if _, err := collection.InsertOne(sc, doc); err != nil {
+				return err
+			}
+			stored, inserted = created, true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		replacement := person
+		if err := checkPerson(&replacement); err != nil {
+			return err
+		}
+		if !setNaturalKey(&replacement, key) {
+			return errKeyMismatch
+		}
+		if mode == onConflictReject {
+			return errPersonExists
+		}
+		preserveManagedFields(&replacement, existing)
+		doc, err := storedPerson(replacement)
+		if err != nil {
+			return err
+		}
+		if _, err := collection.ReplaceOne(sc, bson.M{"_id": existing.ID}, doc); err != nil {
+			return err
+		}
+		stored, changed = replacement, personChanged(existing, replacement)
+		return nil
+	})
+	if errors.Is(err, errPersonExists) {
+		handleClientError(w, http.StatusConflict, "a person with that "+config.NaturalKey+" already exists")
+		return
+	}
+	if errors.Is(err, errKeyMismatch) {
+		handleClientError(w, http.StatusConflict, fmt.Sprintf("%s in the body does not match the path", config.NaturalKey))
+		return
+	}
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	if inserted {
+		writePerson(w, r, http.StatusCreated, stored)
+		return
+	}
+	setUpdateCounts(w, 1, changed)
+	writePerson(w, r, http.StatusOK, stored)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSetNaturalKey(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.NaturalKey = "email"
+
+	p := Person{Name: "Ann"}
+	if !setNaturalKey(&p, "ann@example.com") || p.Email != "ann@example.com" {
+		t.Errorf("setNaturalKey on an empty email: %+v", p)
+	}
+	p = Person{Email: "ann@example.com"}
+	if !setNaturalKey(&p, "ann@example.com") {
+		t.Error("setNaturalKey refused the path's own key")
+	}
+	p = Person{Email: "bob@example.com"}
+	if setNaturalKey(&p, "ann@example.com") {
+		t.Error("setNaturalKey accepted a body with a different key")
+	}
+
+	config.NaturalKey = "name"
+	p = Person{Email: "bob@example.com"}
+	if !setNaturalKey(&p, "Ann") || p.Name != "Ann" {
+		t.Errorf("setNaturalKey by name: %+v", p)
+	}
+	if got := naturalKeyValue(p); got != "Ann" {
+		t.Errorf("naturalKeyValue = %q, want Ann", got)
+	}
+}
+
+func TestParseOnConflict(t *testing.T) {
+	tests := []struct {
+		query string
+		mode  string
+		ok    bool
+	}{
+		{"", onConflictUpdate, true},
+		{"?on_conflict=update", onConflictUpdate, true},
+		{"?on_conflict=reject", onConflictReject, true},
+		{"?on_conflict=ignore", "", false},
+	}
+	for _, tt := range tests {
+		mode, ok := parseOnConflict(httptest.NewRequest("PUT", "/people/x"+tt.query, nil))
+		if mode != tt.mode || ok != tt.ok {
+			t.Errorf("parseOnConflict(%q) = %q, %v; want %q, %v", tt.query, mode, ok, tt.mode, tt.ok)
+		}
+	}
+}
+
+func TestCreatePersonIfNotExists(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	upserted := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: int32(1)},
+		bson.E{Key: "upserted", Value: bson.A{bson.D{{Key: "index", Value: int32(0)}, {Key: "_id", Value: primitive.NewObjectID()}}}})
+	matched := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: int32(1)}, bson.E{Key: "nModified", Value: int32(0)})
+
+	tests := []struct {
+		name, naturalKey, query, body string
+		responses                     []bson.D
+		want                          int
+	}{
+		{"new", "email", "email", `{"name":"Ann","email":"ann@example.com"}`, []bson.D{upserted}, http.StatusCreated},
+		{"exists", "email", "email", `{"name":"Ann","email":"ann@example.com"}`, []bson.D{matched}, http.StatusConflict},
+		{"no key in body", "email", "email", `{"name":"Ann"}`, nil, http.StatusUnprocessableEntity},
+		{"other field", "email", "name", `{"name":"Ann","email":"ann@example.com"}`, nil, http.StatusBadRequest},
+		{"no natural key", "", "email", `{"name":"Ann","email":"ann@example.com"}`, nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		config.NaturalKey = tt.naturalKey
+		runMock(t, tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(tt.responses...)
+			rec := httptest.NewRecorder()
+			CreatePerson(rec, httptest.NewRequest("POST", "/people?if_not_exists="+tt.query, strings.NewReader(tt.body)))
+			if rec.Code != tt.want {
+				mt.Fatalf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.naturalKey == "" && strings.Contains(rec.Body.String(), `""`) {
+				mt.Errorf("error %s names an empty natural key field", rec.Body)
+			}
+			events := mt.GetAllStartedEvents()
+			if tt.responses == nil {
+				if len(events) != 0 {
+					mt.Errorf("a refused request ran %s", events[0].CommandName)
+				}
+				return
+			}
+			// The existence check and the insert are one upsert.
+			if len(events) != 1 || events[0].CommandName != "update" {
+				mt.Fatalf("ran %d commands, want one update", len(events))
+			}
+			update := events[0].Command.Lookup("updates").Array().Index(0).Value().Document()
+			if update.Lookup("q", "email").StringValue() != "ann@example.com" || !update.Lookup("upsert").Boolean() {
+				mt.Errorf("update %s, want an upsert by email", update)
+			}
+		})
+	}
+}
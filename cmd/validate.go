@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"strings"
+)
+
+// Validate checks the business rules for a person. It assumes the person
+// has already been normalized.
+func (p Person) Validate() error {
+	var problems []string
+	if strings.TrimSpace(p.Name) == "" {
+		problems = append(problems, "name is required")
+	}
+	if p.Age < 0 || p.Age > 150 {
+		problems = append(problems, fmt.Sprintf("age %d is out of range 0-150", p.Age))
+	}
+	if p.Email != "" {
+		if addr, err := mail.ParseAddress(p.Email); err != nil || addr.Address != p.Email {
+			problems = append(problems, fmt.Sprintf("email %q is not a valid address", p.Email))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// readPerson reads a person from the request body and drops server managed
+// fields. Bodies that aren't a JSON object, or don't fit the Person fields'
+// types, get a 400. It reports whether reading succeeded; on failure the
+// response has already been written. The person still has to go through
+// checkPerson.
+func readPerson(w http.ResponseWriter, r *http.Request, person *Person) bool {
+	if err := decodeObject(r.Body, person, false); err != nil {
+		switch {
+		case errors.Is(err, io.EOF):
+			handleClientError(w, http.StatusBadRequest, "request body is empty")
+		case errors.Is(err, errNotObject):
+			handleClientError(w, http.StatusBadRequest, err.Error())
+		default:
+			handleClientError(w, http.StatusBadRequest, "malformed JSON: "+err.Error())
+		}
+		return false
+	}
+	person.CreatedAt, person.UpdatedAt, person.DeletedAt = nil, nil, nil
+	person.CreatedBy, person.UpdatedBy = "", ""
+	return true
+}
+
+// invalidPerson is the error checkPerson returns, with the status the
+// request gets.
+type invalidPerson struct {
+	status int
+	msg    string
+}
+
+func (e invalidPerson) Error() string {
+	return e.msg
+}
+
+// checkPerson sanitizes, normalizes and validates a person about to be
+// written. Text or tags that can't be stored as sent get a 400; well-formed
+// JSON that breaks a business rule gets a 422 Unprocessable Entity.
+func checkPerson(person *Person) error {
+	if err := sanitizePerson(person); err != nil {
+		return invalidPerson{http.StatusBadRequest, err.Error()}
+	}
+	normalizePerson(person)
+	if err := checkTagLimits(person.Tags); err != nil {
+		return invalidPerson{http.StatusBadRequest, err.Error()}
+	}
+	if err := person.Validate(); err != nil {
+		return invalidPerson{http.StatusUnprocessableEntity, err.Error()}
+	}
+	return nil
+}
+
+var errNotObject = errors.New("request body must be an object")
+
+// decodeObject decodes a JSON object from body into v. Anything else, in
+// particular a literal null, which would otherwise decode into a zero value
+// without error, fails with errNotObject.
+func decodeObject(body io.Reader, v interface{}, disallowUnknown bool) error {
+	var raw json.RawMessage
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return err
+	}
+	if raw = bytes.TrimSpace(raw); raw[0] != '{' {
+		return errNotObject
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if disallowUnknown {
+		dec.DisallowUnknownFields()
+	}
+	return dec.Decode(v)
+}
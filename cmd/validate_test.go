@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadAndCheckPerson(t *testing.T) {
+	tests := []struct {
+		body   string
+		status int // 0 for success
+	}{
+		{`{"name":"Ann","age":30}`, 0},
+		{``, http.StatusBadRequest},
+		{`null`, http.StatusBadRequest},
+		{`[{"name":"Ann"}]`, http.StatusBadRequest},
+		{`{"name":`, http.StatusBadRequest},
+		{`{"name":"Ann","age":"thirty"}`, http.StatusBadRequest},
+		{`{"age":30}`, http.StatusUnprocessableEntity},
+		{`{"name":"Ann","age":200}`, http.StatusUnprocessableEntity},
+		{`{"name":"Ann","email":"not an address"}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/people", strings.NewReader(tt.body))
+		var p Person
+		ok := readPerson(rec, r, &p)
+		if ok {
+			if err := checkPerson(&p); err != nil {
+				handleError(rec, err)
+				ok = false
+			}
+		}
+		switch {
+		case tt.status == 0 && !ok:
+			t.Errorf("person %s failed with %d: %s", tt.body, rec.Code, rec.Body)
+		case tt.status != 0 && (ok || rec.Code != tt.status):
+			t.Errorf("person %s: ok %v, status %d; want false, %d", tt.body, ok, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestReadPersonDropsManagedFields(t *testing.T) {
+	body := `{"name":"Ann","created_at":"2020-01-01T00:00:00Z","updated_by":"mallory"}`
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/people", strings.NewReader(body))
+	var p Person
+	if !readPerson(rec, r, &p) {
+		t.Fatalf("readPerson failed with %d: %s", rec.Code, rec.Body)
+	}
+	if p.CreatedAt != nil || p.UpdatedBy != "" {
+		t.Errorf("decoded managed fields %v, %q; want them dropped", p.CreatedAt, p.UpdatedBy)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// shutdownCtx is cancelled when the server starts shutting down. Background
+// workers and long-lived streams must return once it is done.
+var shutdownCtx, cancelShutdown = context.WithCancel(context.Background())
+
+var workers = struct {
+	sync.Mutex
+	wg      sync.WaitGroup
+	running map[string]int
+}{running: map[string]int{}}
+
+// startWorker runs fn in its own goroutine with shutdownCtx, and keeps
+// track of it so shutdown can wait for it to return.
+func startWorker(name string, fn func(ctx context.Context)) {
+	done := trackWorker(name)
+	go func() {
+		defer done()
+		fn(shutdownCtx)
+	}()
+}
+
+// trackWorker registers a running worker or stream under name. The
+// returned function must be called once it has stopped.
+func trackWorker(name string) func() {
+	workers.Lock()
+	workers.running[name]++
+	workers.wg.Add(1)
+	workers.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			workers.Lock()
+			if workers.running[name]--; workers.running[name] == 0 {
+				delete(workers.running, name)
+			}
+			workers.Unlock()
+			workers.wg.Done()
+		})
+	}
+}
+
+// stopWorkers cancels shutdownCtx and waits up to timeout for every tracked
+// worker to return. It returns the names of the workers still running.
+func stopWorkers(timeout time.Duration) []string {
+	cancelShutdown()
+
+	stopped := make(chan struct{})
+	go func() {
+		workers.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-time.After(timeout):
+	}
+
+	workers.Lock()
+	defer workers.Unlock()
+	var names []string
+	for name := range workers.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStopWorkers(t *testing.T) {
+	defer func(ctx context.Context, cancel context.CancelFunc) {
+		shutdownCtx, cancelShutdown = ctx, cancel
+	}(shutdownCtx, cancelShutdown)
+	shutdownCtx, cancelShutdown = context.WithCancel(context.Background())
+
+	stopped := make(chan struct{})
+	startWorker("monitor", func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	})
+	release := make(chan struct{})
+	startWorker("stuck", func(ctx context.Context) {
+		<-release
+	})
+
+	if stuck := stopWorkers(50 * time.Millisecond); !reflect.DeepEqual(stuck, []string{"stuck"}) {
+		t.Errorf("stopWorkers = %v, want [stuck]", stuck)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("the monitor is still running after its context was cancelled")
+	}
+
+	close(release)
+	if stuck := stopWorkers(time.Second); stuck != nil {
+		t.Errorf("stopWorkers after every worker returned = %v", stuck)
+	}
+}